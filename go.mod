@@ -5,14 +5,26 @@ go 1.23.3
 require (
 	github.com/alecthomas/chroma v0.10.0
 	github.com/fatih/color v1.18.0
+	github.com/gdamore/tcell/v2 v2.7.4
+	github.com/mattn/go-isatty v0.0.20
+	github.com/mattn/go-runewidth v0.0.15
+	github.com/miekg/dns v1.1.62
 	github.com/olekukonko/tablewriter v0.0.5
+	github.com/rivo/tview v0.0.0-20240625185742-b0a7293b8130
+	golang.org/x/net v0.27.0
+	golang.org/x/text v0.16.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
 	github.com/dlclark/regexp2 v1.4.0 // indirect
+	github.com/gdamore/encoding v1.0.0 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
+	golang.org/x/term v0.22.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 )
@@ -4,20 +4,49 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/rand/v2"
 	"net"
+	"net/http"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/alecthomas/chroma/quick"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/mattn/go-runewidth"
+	"github.com/miekg/dns"
 	"github.com/olekukonko/tablewriter"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/proxy"
+	"golang.org/x/text/unicode/norm"
 	"gopkg.in/yaml.v2"
 )
 
@@ -27,22 +56,649 @@ type DomainTXT struct {
 	TXT    string `json:"txt" yaml:"txt"`
 	Key    string `json:"key" yaml:"key"`
 	Value  string `json:"value" yaml:"value"`
+	// ValueType classifies Value, e.g. "base64" or "ip"; see classifyValue.
+	ValueType string `json:"value_type,omitempty" yaml:"value_type,omitempty"`
+	// Chunks is the number of character-strings the TXT record was split
+	// into on the wire; set with --chunk-info.
+	Chunks int `json:"chunks,omitempty" yaml:"chunks,omitempty"`
+	// Wildcard is set when the record is also returned for a random
+	// nonexistent sibling name, i.e. it comes from a wildcard; see
+	// --detect-wildcards.
+	Wildcard bool `json:"wildcard,omitempty" yaml:"wildcard,omitempty"`
+	// Parked is set when the domain's NS or MX records match a known
+	// parking provider, named in ParkedWith; set with --detect-parked.
+	Parked     bool   `json:"parked,omitempty" yaml:"parked,omitempty"`
+	ParkedWith string `json:"parked_with,omitempty" yaml:"parked_with,omitempty"`
+	// Zone is the DNS zone the domain belongs to and Authority the
+	// nameservers of its NS set; set with --show-authority.
+	Zone      string   `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Authority []string `json:"authority,omitempty" yaml:"authority,omitempty"`
+	// CNAMEChain lists the names from the queried domain to the one holding
+	// the record when it was reached through CNAMEs; set with
+	// --include-cname-value.
+	CNAMEChain []string `json:"cname_chain,omitempty" yaml:"cname_chain,omitempty"`
+	// Raw is the record as published when TXT holds a normalized form; set
+	// with --normalize-spf, --compress-values or --normalize-unicode.
+	Raw string `json:"raw,omitempty" yaml:"raw,omitempty"`
+	// References holds the A/MX resolution of domains the record refers
+	// to (SPF include:/redirect=, DMARC rua/ruf); set with
+	// --expand-references.
+	References []*Reference `json:"references,omitempty" yaml:"references,omitempty"`
+	// Meta carries the extra columns of the input row the domain came from;
+	// set with --append-metadata-columns.
+	Meta map[string]string `json:"meta,omitempty" yaml:"meta,omitempty"`
+	// TruncatedFrom is the number of TXT records the domain returned when
+	// more than --max-txt-per-query were dropped.
+	TruncatedFrom int `json:"truncated_from,omitempty" yaml:"truncated_from,omitempty"`
+	// Dig is the record as dig prints it: each character-string quoted and
+	// escaped, in the segments it was published in; set with --compat-dig.
+	Dig string `json:"dig,omitempty" yaml:"dig,omitempty"`
+	// segments are the character-strings Dig was built from, kept so that
+	// --redact can rebuild Dig from the masked record.
+	segments []string
+	// Tag labels the run the result came from; set with --tag.
+	Tag string `json:"tag,omitempty" yaml:"tag,omitempty"`
+	// LookupMs is how long looking up the domain took, in milliseconds;
+	// set with --show-timing.
+	LookupMs float64 `json:"lookup_ms,omitempty" yaml:"lookup_ms,omitempty"`
+	// Disagreement is set when --resolver-strategy all got different
+	// answers from the resolvers; ResolverAnswers holds what each returned.
+	Disagreement    bool             `json:"disagreement,omitempty" yaml:"disagreement,omitempty"`
+	ResolverAnswers []ResolverAnswer `json:"resolver_answers,omitempty" yaml:"resolver_answers,omitempty"`
+	// Type is set for records other than TXT (e.g. "CAA").
+	Type  string       `json:"type,omitempty" yaml:"type,omitempty"`
+	CAA   *CAARecord   `json:"caa,omitempty" yaml:"caa,omitempty"`
+	SRV   *SRVRecord   `json:"srv,omitempty" yaml:"srv,omitempty"`
+	NAPTR *NAPTRRecord `json:"naptr,omitempty" yaml:"naptr,omitempty"`
+}
+
+// txtColumn returns the record as shown in tables and CSV: in dig's form
+// with --compat-dig, as text otherwise.
+func (r DomainTXT) txtColumn() string {
+	if r.Dig != "" {
+		return r.Dig
+	}
+	return r.TXT
+}
+
+// domainColumn returns the domain as shown in tables and CSV, marked with
+// the number of records it returned when --max-txt-per-query dropped some.
+func (r DomainTXT) domainColumn() string {
+	if r.TruncatedFrom > 0 {
+		return fmt.Sprintf("%s (truncated from %d)", r.Domain, r.TruncatedFrom)
+	}
+	return r.Domain
+}
+
+// truncatedDomain matches the domain cells written by domainColumn for
+// truncated domains.
+var truncatedDomain = regexp.MustCompile(`^(.*) \(truncated from ([0-9]+)\)$`)
+
+// CAARecord holds the parsed fields of a CAA record.
+type CAARecord struct {
+	Flags uint8  `json:"flags" yaml:"flags"`
+	Tag   string `json:"tag" yaml:"tag"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// SRVRecord holds the parsed fields of an SRV record.
+type SRVRecord struct {
+	Priority uint16 `json:"priority" yaml:"priority"`
+	Weight   uint16 `json:"weight" yaml:"weight"`
+	Port     uint16 `json:"port" yaml:"port"`
+	Target   string `json:"target" yaml:"target"`
+}
+
+// NAPTRRecord holds the parsed fields of a NAPTR record.
+type NAPTRRecord struct {
+	Order       uint16 `json:"order" yaml:"order"`
+	Preference  uint16 `json:"preference" yaml:"preference"`
+	Flags       string `json:"flags" yaml:"flags"`
+	Service     string `json:"service" yaml:"service"`
+	Regexp      string `json:"regexp" yaml:"regexp"`
+	Replacement string `json:"replacement" yaml:"replacement"`
+}
+
+// SPFNode is a single SPF record in an include tree, along with the records
+// it pulls in via include: and redirect=.
+type SPFNode struct {
+	Domain     string     `json:"domain" yaml:"domain"`
+	Mechanisms []string   `json:"mechanisms" yaml:"mechanisms"`
+	Includes   []*SPFNode `json:"includes,omitempty" yaml:"includes,omitempty"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// SPFTree holds the resolved SPF include hierarchy for a queried domain.
+type SPFTree struct {
+	Domain        string   `json:"domain" yaml:"domain"`
+	IncludeDepth  int      `json:"include_depth" yaml:"include_depth"`
+	DepthExceeded bool     `json:"depth_exceeded,omitempty" yaml:"depth_exceeded,omitempty"`
+	SPF           *SPFNode `json:"spf" yaml:"spf"`
+}
+
+// EnvelopeMeta describes the run that produced a set of results.
+type EnvelopeMeta struct {
+	GeneratedAt string `json:"generated_at" yaml:"generated_at"`
+	Resolver    string `json:"resolver" yaml:"resolver"`
+	DomainCount int    `json:"domain_count" yaml:"domain_count"`
+	Version     string `json:"version" yaml:"version"`
+}
+
+// Envelope wraps JSON and YAML results with run metadata for --envelope.
+type Envelope struct {
+	Meta    EnvelopeMeta `json:"meta" yaml:"meta"`
+	Results interface{}  `json:"results" yaml:"results"`
+}
+
+// envelopeMeta is set when --envelope is given.
+var envelopeMeta *EnvelopeMeta
+
+// wrapEnvelope returns data wrapped in an Envelope when --envelope is set,
+// and data unchanged otherwise.
+func wrapEnvelope(data interface{}) interface{} {
+	if envelopeMeta == nil {
+		return data
+	}
+	return Envelope{Meta: *envelopeMeta, Results: data}
+}
+
+// version reports the module version dnxty was built from.
+func version() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// BenchmarkResult summarizes a --benchmark run against a resolver.
+type BenchmarkResult struct {
+	Resolver     string  `json:"resolver" yaml:"resolver"`
+	Queries      int     `json:"queries" yaml:"queries"`
+	Succeeded    int     `json:"succeeded" yaml:"succeeded"`
+	SuccessRate  float64 `json:"success_rate" yaml:"success_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms" yaml:"avg_latency_ms"`
+}
+
+// DomainRecords holds every result for one domain grouped by record type,
+// as printed by --group-records.
+type DomainRecords struct {
+	Domain  string                 `json:"domain" yaml:"domain"`
+	Records map[string][]DomainTXT `json:"records" yaml:"records"`
+}
+
+// groupRecords groups results by domain, in order of first appearance, and
+// then by record type.
+func groupRecords(results []DomainTXT) []DomainRecords {
+	var grouped []DomainRecords
+	index := make(map[string]int)
+	for _, r := range results {
+		i, ok := index[r.Domain]
+		if !ok {
+			i = len(grouped)
+			index[r.Domain] = i
+			grouped = append(grouped, DomainRecords{Domain: r.Domain, Records: make(map[string][]DomainTXT)})
+		}
+		rrType := r.Type
+		if rrType == "" {
+			rrType = "TXT"
+		}
+		grouped[i].Records[rrType] = append(grouped[i].Records[rrType], r)
+	}
+	return grouped
+}
+
+// SPFSummary is the one-line SPF posture of a domain reported by --spf-summary.
+type SPFSummary struct {
+	Domain   string `json:"domain" yaml:"domain"`
+	HasSPF   bool   `json:"has_spf" yaml:"has_spf"`
+	All      string `json:"all,omitempty" yaml:"all,omitempty"`
+	Includes int    `json:"includes" yaml:"includes"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// SPFLookupCount is the number of DNS lookups evaluating a domain's SPF
+// record incurs, reported by --explain-spf-limit.
+type SPFLookupCount struct {
+	Domain    string         `json:"domain" yaml:"domain"`
+	Lookups   int            `json:"lookups" yaml:"lookups"`
+	Exceeded  bool           `json:"exceeded" yaml:"exceeded"`
+	Breakdown map[string]int `json:"breakdown,omitempty" yaml:"breakdown,omitempty"`
+	Error     string         `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Finding is a problem --validate-spf or --validate-dmarc found in a
+// record. Severity is error (the record fails or misbehaves), warning (risky
+// or deprecated) or info.
+type Finding struct {
+	Severity string `json:"severity" yaml:"severity"`
+	Term     string `json:"term,omitempty" yaml:"term,omitempty"`
+	Message  string `json:"message" yaml:"message"`
+}
+
+// SPFValidation holds the --validate-spf findings for a domain.
+type SPFValidation struct {
+	Domain   string    `json:"domain" yaml:"domain"`
+	Findings []Finding `json:"findings" yaml:"findings"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// DMARCValidation holds the --validate-dmarc findings for a domain.
+type DMARCValidation struct {
+	Domain   string    `json:"domain" yaml:"domain"`
+	Findings []Finding `json:"findings" yaml:"findings"`
+	Error    string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// MatrixRow records which of the --matrix keys a domain has.
+type MatrixRow struct {
+	Domain string          `json:"domain" yaml:"domain"`
+	Keys   map[string]bool `json:"keys" yaml:"keys"`
+}
+
+// UnparsedRecord is a TXT record that yielded no key/value pair, as output
+// by --unparsed.
+type UnparsedRecord struct {
+	Domain string `json:"domain" yaml:"domain"`
+	TXT    string `json:"txt" yaml:"txt"`
+	Parsed bool   `json:"parsed" yaml:"parsed"`
+}
+
+// Reference is a domain referred to by a record, resolved by
+// --expand-references. With a depth above 1, References holds the domains
+// its own TXT records refer to.
+type Reference struct {
+	Domain     string       `json:"domain" yaml:"domain"`
+	A          []string     `json:"a,omitempty" yaml:"a,omitempty"`
+	MX         []string     `json:"mx,omitempty" yaml:"mx,omitempty"`
+	Error      string       `json:"error,omitempty" yaml:"error,omitempty"`
+	References []*Reference `json:"references,omitempty" yaml:"references,omitempty"`
+}
+
+// SharedValue is a value found on more than one domain, as output by
+// --dedupe-values-global.
+type SharedValue struct {
+	Value   string   `json:"value" yaml:"value"`
+	Domains []string `json:"domains" yaml:"domains"`
+}
+
+// SharedToken is a verification token found on more than one domain, as
+// output by --find-shared-tokens.
+type SharedToken struct {
+	Key     string   `json:"key" yaml:"key"`
+	Token   string   `json:"token" yaml:"token"`
+	Count   int      `json:"count" yaml:"count"`
+	Domains []string `json:"domains" yaml:"domains"`
+}
+
+// DomainHash is the fingerprint of a domain's TXT record set, as output by
+// --hash.
+type DomainHash struct {
+	Domain string `json:"domain" yaml:"domain"`
+	Hash   string `json:"hash" yaml:"hash"`
+}
+
+// Disagreement is a lookup the resolvers answered differently, as output by
+// --disagreements.
+type Disagreement struct {
+	Domain  string           `json:"domain" yaml:"domain"`
+	Type    string           `json:"type" yaml:"type"`
+	Answers []ResolverAnswer `json:"answers" yaml:"answers"`
+}
+
+// DomainSummary is the one-line overview of a domain output by --summary.
+type DomainSummary struct {
+	Domain  string   `json:"domain" yaml:"domain"`
+	Records int      `json:"records" yaml:"records"`
+	Keys    []string `json:"keys" yaml:"keys"`
+	SPF     bool     `json:"spf" yaml:"spf"`
+	DMARC   bool     `json:"dmarc" yaml:"dmarc"`
+}
+
+// summarizeDomain summarizes domain from the TXT records it publishes,
+// which are counted and checked for SPF whatever the filters, and from
+// results, the results dnxty kept for it, whose keys are listed in order of
+// first appearance. DMARC is left for the caller.
+func summarizeDomain(domain string, published []string, results []DomainTXT) DomainSummary {
+	sum := DomainSummary{Domain: domain, Records: len(published), Keys: []string{}}
+	for _, txt := range published {
+		sum.SPF = sum.SPF || isSPF(txt)
+	}
+	keys := make(map[string]bool)
+	for _, r := range results {
+		if r.Key != "" && !keys[r.Key] {
+			keys[r.Key] = true
+			sum.Keys = append(sum.Keys, r.Key)
+		}
+	}
+	return sum
+}
+
+// DomainError is a domain whose lookup failed, as output by --only-errors.
+type DomainError struct {
+	Domain   string `json:"domain" yaml:"domain"`
+	Category string `json:"category" yaml:"category"`
+	Error    string `json:"error" yaml:"error"`
 }
 
 // SimpleResult holds the simplified output for a domain.
 type SimpleResult struct {
 	Domain string `json:"domain" yaml:"domain"`
 	Key    string `json:"key" yaml:"key"`
+	// Count is the number of records that simplified to Key; set with
+	// --simple-count.
+	Count int    `json:"count,omitempty" yaml:"count,omitempty"`
+	Tag   string `json:"tag,omitempty" yaml:"tag,omitempty"`
 }
 
-// simplifyKey returns the substring of key before the first "-" (if present).
+// simplifyKey collapses key according to --simplify-mode: the substring
+// before the first --key-delimiter (prefix), after the last one (suffix), or
+// the whole key (full).
 func simplifyKey(key string) string {
-	if idx := strings.Index(key, "-"); idx != -1 {
-		return key[:idx]
+	switch simplifyMode {
+	case "suffix":
+		if idx := strings.LastIndex(key, keyDelimiter); idx != -1 {
+			return key[idx+len(keyDelimiter):]
+		}
+	case "full":
+	default:
+		if idx := strings.Index(key, keyDelimiter); idx != -1 {
+			return key[:idx]
+		}
 	}
 	return key
 }
 
+// PostProcessor transforms or filters a single result before output.
+// Returning false drops the result.
+type PostProcessor func(DomainTXT) (DomainTXT, bool)
+
+// postProcessors holds the registered post-processors in registration order.
+var postProcessors []PostProcessor
+
+// RegisterPostProcessor adds p to the chain every result passes through
+// before output, allowing results to be transformed or filtered without
+// touching the lookup and extraction code.
+func RegisterPostProcessor(p PostProcessor) {
+	postProcessors = append(postProcessors, p)
+}
+
+// applyPostProcessors runs results through the registered post-processors,
+// dropping any result a post-processor rejects.
+func applyPostProcessors(results []DomainTXT) []DomainTXT {
+	if len(postProcessors) == 0 {
+		return results
+	}
+	kept := results[:0]
+	for _, r := range results {
+		ok := true
+		for _, p := range postProcessors {
+			if r, ok = p(r); !ok {
+				break
+			}
+		}
+		if ok {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// hostnameLabel matches a single DNS label. Underscores are allowed for names
+// such as _dmarc and selector._domainkey.
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9_]([A-Za-z0-9_-]*[A-Za-z0-9_])?$`)
+
+// toASCII converts internationalized names to punycode (bücher.example to
+// xn--bcher-kva.example). Underscores stay allowed, for labels like _dmarc.
+var toASCII = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.Transitional(false))
+
+// sanitizeDomain extracts the hostname from pasted input such as
+// "https://user@example.com:443/path", "example.com:443" or
+// "user@example.com". Internationalized names are converted to punycode and
+// a leading "*." wildcard label is kept. It returns an error when the result
+// is not a valid hostname.
+func sanitizeDomain(input string) (string, error) {
+	host := strings.TrimSpace(input)
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?#"); i != -1 {
+		host = host[:i]
+	}
+	if i := strings.LastIndex(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	// Only names with non-ASCII characters are mapped, so ASCII input keeps
+	// its case for --preserve-case.
+	for _, c := range host {
+		if c >= utf8.RuneSelf {
+			ascii, err := toASCII.ToASCII(host)
+			if err != nil {
+				return "", fmt.Errorf("not a valid hostname: %v", err)
+			}
+			host = ascii
+			break
+		}
+	}
+	name, _ := strings.CutPrefix(host, "*.")
+	if name == "" || len(host) > 253 {
+		return "", fmt.Errorf("not a valid hostname")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 || !hostnameLabel.MatchString(label) {
+			return "", fmt.Errorf("not a valid hostname")
+		}
+	}
+	return host, nil
+}
+
+// mergeValues combines results sharing the same domain and key into a single
+// row, joining their values (and TXT records) with sep. Rows without a key are
+// left untouched. The order of first appearance is preserved.
+func mergeValues(results []DomainTXT, sep string) []DomainTXT {
+	var merged []DomainTXT
+	index := make(map[string]int)
+	for _, r := range results {
+		if r.Key == "" {
+			merged = append(merged, r)
+			continue
+		}
+		id := r.Domain + "\x00" + r.Key
+		if i, ok := index[id]; ok {
+			merged[i].TXT += sep + r.TXT
+			merged[i].Value += sep + r.Value
+			continue
+		}
+		index[id] = len(merged)
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// keyValue captures key=value pairs (commonly used for domain verification).
+// keyValueOrEmpty also captures key-only pairs such as "key=" for
+// --include-empty-value.
+var (
+	keyValue        = regexp.MustCompile(`([\w\.\-]+)=([A-Za-z0-9\+\/=]+)`)
+	keyValueOrEmpty = regexp.MustCompile(`([\w\.\-]+)=([A-Za-z0-9\+\/=]*)`)
+)
+
+// redactValue masks all but the first and last four characters of value;
+// values too short to keep both ends are masked entirely.
+func redactValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:4]) + strings.Repeat("*", len(runes)-8) + string(runes[len(runes)-4:])
+}
+
+// redactResult is the --redact post-processor. It masks the value wherever
+// it appears in the result, along with every other value in the record,
+// keeping keys visible. The published form in Raw and the answers of each
+// resolver are masked the same way as TXT.
+func redactResult(r DomainTXT) (DomainTXT, bool) {
+	r.TXT = redactText(r.TXT)
+	r.Raw = redactText(r.Raw)
+	if r.ResolverAnswers != nil {
+		// The answers are shared by every result of the domain, so mask a
+		// copy rather than masking them again for each result.
+		answers := make([]ResolverAnswer, len(r.ResolverAnswers))
+		for i, a := range r.ResolverAnswers {
+			a.Records = slices.Clone(a.Records)
+			for j := range a.Records {
+				a.Records[j] = redactText(a.Records[j])
+			}
+			answers[i] = a
+		}
+		r.ResolverAnswers = answers
+	}
+	if r.Dig != "" {
+		// Mask the published text as a whole, so that values split across
+		// character-strings are caught, then cut it back into segments.
+		published := redactText(strings.Join(r.segments, ""))
+		if r.Value != "" {
+			published = strings.ReplaceAll(published, r.Value, redactValue(r.Value))
+		}
+		r.segments = splitLike(published, r.segments)
+		r.Dig = digTXT(r.segments)
+	}
+	if r.Value == "" {
+		return r, true
+	}
+	masked := redactValue(r.Value)
+	r.TXT = strings.ReplaceAll(r.TXT, r.Value, masked)
+	r.Raw = strings.ReplaceAll(r.Raw, r.Value, masked)
+	if r.CAA != nil {
+		caa := *r.CAA
+		caa.Value = strings.ReplaceAll(caa.Value, r.Value, masked)
+		r.CAA = &caa
+	}
+	r.Value = masked
+	return r, true
+}
+
+// maskRecords returns records with their values masked as redactResult masks
+// a record's text when --redact is set, and records itself otherwise. It is
+// for output that bypasses the post-processors, such as --raw, --explain and
+// the resolver disagreement reports.
+func maskRecords(records []string) []string {
+	if !redact {
+		return records
+	}
+	masked := make([]string, len(records))
+	for i, txt := range records {
+		masked[i] = redactText(txt)
+	}
+	return masked
+}
+
+// splitLike cuts s into pieces of the same lengths as segments, with any
+// remainder going to the last piece.
+func splitLike(s string, segments []string) []string {
+	pieces := make([]string, len(segments))
+	for i, seg := range segments {
+		if i == len(segments)-1 || len(seg) >= len(s) {
+			pieces[i], s = s, ""
+			continue
+		}
+		pieces[i], s = s[:len(seg)], s[len(seg):]
+	}
+	return pieces
+}
+
+// redactText masks the value of every key=value pair in s.
+func redactText(s string) string {
+	return keyValue.ReplaceAllStringFunc(s, func(pair string) string {
+		m := keyValue.FindStringSubmatch(pair)
+		return m[1] + "=" + redactValue(m[2])
+	})
+}
+
+// base64Blob matches long, unbroken runs of base64 characters such as
+// verification tokens and DKIM keys.
+var base64Blob = regexp.MustCompile(`^[A-Za-z0-9\+\/]{32,}={0,2}$`)
+
+var (
+	emailValue = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	urlValue   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.\-]*://\S+$`)
+	numericVal = regexp.MustCompile(`^[+-]?[0-9]+(\.[0-9]+)?$`)
+	tokenValue = regexp.MustCompile(`^[A-Za-z0-9_\-\.]{8,}$`)
+)
+
+// classifyValue tags a value as base64, ip, email, url, token, numeric or
+// text. Tokens are identifier-like strings mixing letters and digits.
+func classifyValue(value string) string {
+	switch {
+	case value == "":
+		return ""
+	case net.ParseIP(value) != nil:
+		return "ip"
+	case emailValue.MatchString(value):
+		return "email"
+	case urlValue.MatchString(value):
+		return "url"
+	case numericVal.MatchString(value):
+		return "numeric"
+	case base64Blob.MatchString(value):
+		return "base64"
+	case tokenValue.MatchString(value) &&
+		strings.ContainsAny(value, "0123456789") &&
+		strings.IndexFunc(value, unicode.IsLetter) != -1:
+		return "token"
+	}
+	return "text"
+}
+
+// valueColors returns the pretty table cell colors for a value. Long base64
+// blobs are dimmed so short, human-readable values stand out during triage.
+func valueColors(value string) tablewriter.Colors {
+	if color.NoColor || !base64Blob.MatchString(value) {
+		return tablewriter.Colors{}
+	}
+	return tablewriter.Colors{tablewriter.FgHiBlackColor}
+}
+
+// ansiStripper is an io.Writer that drops ANSI escape sequences before
+// passing data on to w. It keeps state between writes so sequences split
+// across calls are still removed.
+type ansiStripper struct {
+	w     io.Writer
+	state int // 0: text, 1: after ESC, 2: inside a CSI sequence
+}
+
+func (a *ansiStripper) Write(p []byte) (int, error) {
+	clean := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch a.state {
+		case 0:
+			if b == 0x1b {
+				a.state = 1
+				continue
+			}
+			clean = append(clean, b)
+		case 1:
+			if b == '[' {
+				a.state = 2
+			} else {
+				a.state = 0
+			}
+		case 2:
+			// A CSI sequence ends with a byte in the range 0x40-0x7e.
+			if b >= 0x40 && b <= 0x7e {
+				a.state = 0
+			}
+		}
+	}
+	if _, err := a.w.Write(clean); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 // printFlagDefaults prints all defined flags with a double-dash (--)
 // before each flag name. It prints a type hint ("string") for non-bool flags
 // and includes the default value when appropriate.
@@ -63,411 +719,5133 @@ func printFlagDefaults() {
 	})
 }
 
-var (
-	verbose   bool
-	dnsServer string
-)
-
-func init() {
-	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
-	flag.StringVar(&dnsServer, "dns", "", "Specify DNS server to use")
+// typeTimeouts is the value of --timeout: a default duration plus optional
+// per-record-type overrides, written as e.g. "5s,TXT=10s,CAA=2s".
+type typeTimeouts struct {
+	def    time.Duration
+	byType map[string]time.Duration
+}
+
+func (t *typeTimeouts) String() string {
+	if t == nil {
+		return ""
+	}
+	var parts []string
+	for qtype, d := range t.byType {
+		parts = append(parts, qtype+"="+d.String())
+	}
+	sort.Strings(parts)
+	if t.def > 0 {
+		parts = append([]string{t.def.String()}, parts...)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (t *typeTimeouts) Set(s string) error {
+	for _, part := range strings.Split(s, ",") {
+		qtype, value, perType := strings.Cut(strings.TrimSpace(part), "=")
+		if !perType {
+			value = qtype
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return fmt.Errorf("negative timeout %s", value)
+		}
+		if !perType {
+			t.def = d
+			continue
+		}
+		qtype = strings.ToUpper(strings.TrimSpace(qtype))
+		if qtype == "" {
+			return fmt.Errorf("missing record type in %q", part)
+		}
+		if t.byType == nil {
+			t.byType = make(map[string]time.Duration)
+		}
+		t.byType[qtype] = d
+	}
+	return nil
+}
+
+// stringList is a flag that can be repeated, collecting every value.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// forType returns the timeout for a record type, falling back to the
+// default.
+func (t *typeTimeouts) forType(qtype string) time.Duration {
+	if d, ok := t.byType[qtype]; ok {
+		return d
+	}
+	return t.def
+}
+
+var (
+	verbose   bool
+	dnsServer string
+	explain   bool
+	retries   int
+	// queryTimeout bounds a whole lookup; dialTimeout bounds establishing
+	// the connection to the resolver. Zero means the library defaults.
+	queryTimeout typeTimeouts
+	dialTimeout  time.Duration
+	// listTimeout bounds downloading the --input-url domain list.
+	listTimeout time.Duration
+	// compactPretty drops table borders and separators in pretty output.
+	compactPretty bool
+	// showValueType adds the ValueType column to table and CSV output.
+	showValueType bool
+	// redact masks values in the results and in every other place record
+	// text is printed (--raw, --explain, resolver reports and warnings).
+	redact bool
+	// detectWildcards and detectParked flag wildcard records and parked
+	// domains in the results.
+	detectWildcards bool
+	detectParked    bool
+	// simplifyMode and keyDelimiter control how simplifyKey groups keys.
+	simplifyMode string
+	keyDelimiter string
+	// chunkInfo reports how many segments each TXT record arrived in.
+	chunkInfo bool
+	// includeCNAME records the CNAME chain behind each TXT record.
+	includeCNAME bool
+	// ecsSubnet is sent as an EDNS Client Subnet option when --ecs is set.
+	ecsSubnet *net.IPNet
+	// lineBuffered flushes output after every row (--line-buffered), for
+	// consumers such as tail -f that read it while it is written.
+	lineBuffered bool
+	// sourceIP is the local address DNS queries are sent from (--source-ip).
+	sourceIP net.IP
+	// socks5Proxy is the host:port of a SOCKS5 proxy DNS queries are sent
+	// through, over TCP.
+	socks5Proxy string
+	// simpleCount counts the records behind each --simple key.
+	simpleCount bool
+	// runTag labels every result with a run identifier (--tag).
+	runTag string
+	// showAuthority adds the zone and nameservers behind each domain.
+	showAuthority bool
+	// showTiming adds each domain's lookup duration to the results.
+	showTiming bool
+	// showRaw adds a Raw column to tables and CSV when records may be
+	// rewritten (--normalize-spf, --compress-values, --normalize-unicode).
+	showRaw bool
+	// csvDelimiter separates CSV fields; csvCRLF ends CSV lines with \r\n.
+	csvDelimiter = ','
+	csvCRLF      bool
+	// output receives all result output; it is stdout unless --output is set.
+	output io.Writer = os.Stdout
+)
+
+func init() {
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+	flag.StringVar(&dnsServer, "dns", "", "Specify DNS server to use")
+	flag.BoolVar(&explain, "explain", false, "Report on stderr why each TXT record was kept or dropped.")
+	flag.BoolVar(&redact, "redact", false, "Mask the middle of every value (keeping the first and last 4 characters) so results can be shared without leaking secrets, including in --raw, --explain and resolver disagreement output.")
+	flag.Var(&queryTimeout, "timeout", "Timeout for a whole DNS query, e.g. 5s, with optional per-type overrides such as 5s,TXT=10s,CAA=2s (0 uses the resolver default).")
+	flag.DurationVar(&dialTimeout, "dial-timeout", 0, "Timeout for connecting to the DNS server, e.g. 2s (0 uses the default).")
+	flag.DurationVar(&listTimeout, "list-timeout", 30*time.Second, "Timeout for downloading the --input-url domain list, e.g. 1m.")
+	flag.StringVar(&simplifyMode, "simplify-mode", "prefix", "How --simple groups keys: prefix (before the first --key-delimiter), suffix (after the last one) or full (the whole key).")
+	flag.StringVar(&keyDelimiter, "key-delimiter", "-", "Separator --simple splits keys on when grouping them (e.g. _ for google_site_verification).")
+	flag.BoolVar(&includeCNAME, "include-cname-value", false, "Add the CNAME chain (queried -> ... -> resolved) that led to each TXT record to the output.")
+	flag.BoolVar(&chunkInfo, "chunk-info", false, "Add the number of segments each TXT record was split into on the wire to the output.")
+	flag.Func("ecs", "Send this subnet (CIDR, e.g. 203.0.113.0/24) as an EDNS Client Subnet option; resolvers may ignore it.", func(s string) error {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		ecsSubnet = subnet
+		return nil
+	})
+	flag.BoolVar(&lineBuffered, "line-buffered", false, "Flush output after every row instead of in chunks, so CSV and --gzip output can be followed live (e.g. with tail -f).")
+	flag.Func("source-ip", "Send DNS queries from this local address, for multi-homed hosts or source-based resolver ACLs.", func(s string) error {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return fmt.Errorf("%q is not an IP address", s)
+		}
+		local, err := isLocalIP(ip)
+		if err != nil {
+			return fmt.Errorf("listing local addresses: %v", err)
+		}
+		if !local {
+			return fmt.Errorf("%s is not assigned to any local interface", ip)
+		}
+		sourceIP = ip
+		return nil
+	})
+	flag.StringVar(&socks5Proxy, "socks5", "", "Send DNS queries over TCP through the SOCKS5 proxy at host:port.")
+	flag.IntVar(&retries, "retries", 0, "Number of times to retry a failed lookup, with exponential backoff.")
+	flag.BoolVar(&compactPretty, "compact-pretty", false, "Render pretty tables without borders or column separators, for pasting into notes.")
+	flag.Func("csv-delimiter", "Field delimiter for CSV output, a single character (default ,; e.g. ; for European Excel).", func(s string) error {
+		r, size := utf8.DecodeRuneInString(s)
+		if size == 0 || size != len(s) {
+			return errors.New("must be a single character")
+		}
+		if r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return errors.New("must not be a quote, line break or invalid UTF-8")
+		}
+		csvDelimiter = r
+		return nil
+	})
+	flag.BoolVar(&csvCRLF, "csv-crlf", false, "End CSV lines with \\r\\n instead of \\n.")
+	flag.BoolVar(&simpleCount, "simple-count", false, "With --simple, add the number of records that simplified to each key.")
+	flag.StringVar(&runTag, "tag", "", "Label every result with this run identifier, as a tag field (and a Tag column). Applies to record output only, not to reports such as --summary or --validate-spf.")
+	flag.BoolVar(&showAuthority, "show-authority", false, "Add the zone each domain belongs to and its authoritative nameservers (zone and authority fields, and an Authority column).")
+	flag.BoolVar(&showTiming, "show-timing", false, "Add each domain's lookup duration in milliseconds as a lookup_ms field (and a Lookup ms column).")
+	flag.BoolVar(&showValueType, "show-value-type", false, "Add a column with each value's classification (base64, ip, email, url, token, numeric, text) to table and CSV output.")
+	flag.BoolVar(&detectParked, "detect-parked", false, "Check each domain's NS and MX records against known parking providers and flag its records as parked: true (and a Parked column).")
+	flag.BoolVar(&detectWildcards, "detect-wildcards", false, "Query a random nonexistent sibling of each domain and flag records it also returns as wildcard: true (and a Wildcard column).")
+
+	RegisterPostProcessor(func(r DomainTXT) (DomainTXT, bool) {
+		r.ValueType = classifyValue(r.Value)
+		return r, true
+	})
+}
+
+// message is a user-facing line queued for the printer goroutine.
+type message struct {
+	c    *color.Color // nil for uncolored messages
+	text string
+	// flushed, if set, is closed by the printer instead of writing a line.
+	flushed chan struct{}
+}
+
+var (
+	// errorColor and warnColor are used for error and warning messages.
+	errorColor = color.New(color.FgRed)
+	warnColor  = color.New(color.FgYellow)
+
+	messages = make(chan message, 64)
+)
+
+// startPrinter starts the goroutine that writes queued messages to stderr.
+// Routing every message through it keeps lines from concurrent lookups from
+// interleaving.
+func startPrinter() {
+	go func() {
+		for m := range messages {
+			if m.flushed != nil {
+				close(m.flushed)
+			} else if m.c == nil {
+				fmt.Fprintln(os.Stderr, m.text)
+			} else {
+				m.c.Fprintln(color.Error, m.text)
+			}
+		}
+	}()
+}
+
+// flushPrinter waits until all messages queued so far are written. The
+// printer keeps running, so goroutines still logging never block or panic.
+func flushPrinter() {
+	flushed := make(chan struct{})
+	messages <- message{flushed: flushed}
+	<-flushed
+}
+
+// logf queues a formatted message for the printer goroutine, in color c
+// (or uncolored if c is nil).
+func logf(c *color.Color, format string, args ...interface{}) {
+	messages <- message{c: c, text: strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")}
+}
+
+// runTUI browses results interactively, exporting the filtered view with
+// export. It is set only in builds with the tui tag (see tui.go), keeping
+// the TUI library out of the default binary.
+var runTUI func(results []DomainTXT, export func(path string, results []DomainTXT) error) error
+
+// fatalf prints an error message and exits with status 1.
+func fatalf(format string, args ...interface{}) {
+	logf(errorColor, format, args...)
+	exit(1)
+}
+
+// explainRecord reports whether a raw TXT record was kept or dropped, and
+// why, on stderr when --explain is set.
+func explainRecord(domain, txt, verdict string) {
+	if !explain {
+		return
+	}
+	logf(nil, "%s: %s: %q", domain, verdict, maskRecords([]string{txt})[0])
+}
+
+func main() {
+	// exitCode is set by checks that fail the run after output has been
+	// written; it is applied once all other deferred cleanup has run.
+	exitCode := 0
+	defer func() {
+		// Returning from main means the output is complete.
+		keepOutput.Store(true)
+		exit(exitCode)
+	}()
+	startPrinter()
+
+	// Define command-line flags.
+	filePath := flag.String("file", "", "Path to a text file containing domain names (one domain per line).")
+	compatDig := flag.Bool("compat-dig", false, "Show TXT records as dig does (each character-string quoted and escaped, in its published segments) in tables and CSV, and as a dig field in JSON/YAML.")
+	normalizeUnicode := flag.Bool("normalize-unicode", false, "Normalize TXT records to Unicode NFC before extraction, so composed and decomposed forms compare equal, keeping the original in raw (and a Raw column).")
+	compressValues := flag.Bool("compress-values", false, "Collapse runs of whitespace in TXT records to single spaces and trim the ends before extraction, keeping the original in raw (and a Raw column).")
+	strictInput := flag.Bool("strict-input", false, "Abort on the first malformed line of an NDJSON --file instead of skipping it with a warning.")
+	includeFile := flag.String("include-file", "", "Path to a file of domains (one per line); only input domains listed there are looked up.")
+	excludeFile := flag.String("exclude-file", "", "Path to a file of domains (one per line) to skip.")
+	listSubdomains := flag.Bool("list-subdomains", false, "Make --include-file and --exclude-file entries also match their subdomains.")
+	inputURL := flag.String("input-url", "", "HTTP(S) URL of a newline-separated domain list to look up, fetched within --list-timeout.")
+	outputFormat := flag.String("format", "auto", "Output format. Options: auto (default: pretty on a terminal, ndjson otherwise), pretty, json, ndjson, yaml, csv, zone, org, markdown. An --output file's extension takes precedence.")
+	noColor := flag.Bool("no-color", false, "Disable colored output and syntax highlighting.")
+	gzipOutput := flag.Bool("gzip", false, "Compress the --output file with gzip (implied by a .gz extension).")
+	outputDir := flag.String("output-dir", "", "Write each domain's results to its own file (<domain>.<ext>) in this directory (disables color).")
+	plain := flag.Bool("plain", false, "Guarantee output free of ANSI escape sequences, including any embedded in record data.")
+	allRecords := flag.Bool("all", false, "Include all TXT records, even those without a valid key/value pair.")
+	// By default, SPF records are ignored unless --include-spf is set.
+	includeSPF := flag.Bool("include-spf", false, "Include SPF TXT records (records starting with 'v=spf1'). By default, SPF records are ignored.")
+	// New --simple flag: output a simplified view.
+	simple := flag.Bool("simple", false, "Output simplified results: only the domain and a simplified key (deduplicated).")
+	mergeVals := flag.Bool("merge-values", false, "Merge records sharing the same domain and key into one row.")
+	mergeSep := flag.String("merge-separator", ";", "Separator used to join values when --merge-values is set.")
+	uniqDomains := flag.Bool("uniq-domains", false, "Output only the distinct domains that returned at least one record.")
+	parseSPF := flag.Bool("parse-spf", false, "Resolve each domain's SPF include chain and output it as a tree.")
+	var outputPaths stringList
+	flag.Var(&outputPaths, "output", "Write results to this file instead of stdout (disables color), in the format its extension implies. Repeat to write several files.")
+	skipExisting := flag.Bool("skip-existing", false, "Skip domains already present in the --output file and keep their results (json and csv only).")
+	flattenJSON := flag.Bool("flatten-json", false, "Output JSON (and NDJSON) as one flat object mapping domain.key to value, indexing repeated keys.")
+	groupRecordsFlag := flag.Bool("group-records", false, "Output one object per domain with its records grouped by type instead of flat rows.")
+	jsonShape := flag.String("json-shape", "array", "Shape of JSON and NDJSON output. Options: array (default), map (domain -> key -> value; one domain per line in NDJSON).")
+	benchmark := flag.Bool("benchmark", false, "Query a fixed set of well-known domains through each resolver and report its success rate and latency, plus an all row with several --resolvers.")
+	preserveCase := flag.Bool("preserve-case", false, "Display domains as given instead of lowercased (lookups and deduplication are always case-insensitive).")
+	firstOnly := flag.Bool("first-only", false, "Keep only the first qualifying record per domain and move on.")
+	concurrencyArg := flag.String("concurrency", "1", "Number of domains to look up in parallel, or auto to start from the CPU count and adapt to the failure rate.")
+	retryBudgetSize := flag.Int("retry-budget", 100, "Total retries shared by all lookups; once used up, failures are not retried (-1 for unlimited).")
+	cpuProfile := flag.String("cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof.")
+	memProfile := flag.String("memprofile", "", "Write a heap profile to this file when the run ends, for go tool pprof.")
+	showStats := flag.Bool("stats", false, "Print a summary of lookups, failures and retries to stderr when done.")
+	minSuccess := flag.Float64("min-success", 0, "Exit nonzero if the fraction of domains resolved without error is below this threshold (0-1).")
+	sanitize := flag.Bool("sanitize-input", true, "Reduce inputs like URLs, host:port and user@host to the bare hostname and skip inputs that are not hostnames. On by default; use --sanitize-input=false to query inputs as-is.")
+	prefix := flag.String("prefix", "", "Label to prepend to every domain before lookup (e.g. _dmarc or selector._domainkey).")
+	explainSPFLimit := flag.Bool("explain-spf-limit", false, "Count the DNS lookups (include, a, mx, ptr, exists, redirect) across each domain's resolved SPF tree and flag domains over the limit of 10.")
+	validateSPFFlag := flag.Bool("validate-spf", false, "Lint each domain's SPF records (multiple records, syntax errors, ptr, permissive or missing all, lookup limit) and output the findings with their severity.")
+	validateDMARCFlag := flag.Bool("validate-dmarc", false, "Lint each domain's DMARC record (missing or invalid tags, pct range, unauthorized external report addresses) and output the findings with their severity.")
+	spfSummary := flag.Bool("spf-summary", false, "Output one line per domain summarizing its SPF record: presence, all qualifier and include count.")
+	envelope := flag.Bool("envelope", false, "Wrap JSON and YAML output in an object with run metadata (generation time, resolver, domain count, version).")
+	resolvConf := flag.String("use-resolv-conf", "", "Use the nameservers of this resolv.conf file (e.g. /etc/resolv.conf) as the resolver list, instead of the Go resolver's own selection.")
+	resolverList := flag.String("resolvers", "", "Comma-separated DNS servers to spread queries across in turn (instead of --dns).")
+	perResolver := flag.Int("concurrency-per-resolver", 0, "Maximum queries in flight to each --resolvers server (0 for no per-server limit).")
+	rotateOnError := flag.Int("resolver-rotate-on-error", 0, "Stop using a --resolvers server after N consecutive failed queries (0 = never).")
+	rotateCooldown := flag.Duration("resolver-cooldown", 0, "Use a server dropped by --resolver-rotate-on-error again after this long (0 = never).")
+	disagreements := flag.Bool("disagreements", false, "Report what each resolver returned for domains the resolvers answered differently (requires --resolver-strategy all).")
+	resolverStrategy := flag.String("resolver-strategy", "round-robin", "How queries use the --resolvers servers: round-robin (spread), first (in order until one answers), fastest (race all), all (query all, warn on disagreement and add a Disagreement column).")
+	expandRefs := flag.Int("expand-references", 0, "Resolve (A/MX) the domains records refer to via SPF include:/redirect= and DMARC rua/ruf, following references this many levels deep (0 to disable).")
+	includeEmpty := flag.Bool("include-empty-value", false, "Emit key-only pairs (e.g. \"key=\") with an empty value instead of dropping them.")
+	normalizeSPF := flag.Bool("normalize-spf", false, "With --include-spf, rewrite SPF records in a canonical form (lowercase, single spaces, explicit qualifiers), keeping the original as raw (and a Raw column).")
+	sortSPF := flag.Bool("sort-spf", false, "With --normalize-spf, also sort SPF mechanisms (all stays last, modifiers after it). Changes evaluation order, so use it for comparison only.")
+	syslogOn := flag.Bool("syslog", false, "Also send each result as a JSON syslog message (RFC 5424) to --syslog-addr.")
+	syslogAddr := flag.String("syslog-addr", "udp://127.0.0.1:514", "Syslog collector address for --syslog: [udp://|tcp://]host:port.")
+	maxTXTPerQuery := flag.Int("max-txt-per-query", 1000, "Keep at most N TXT records per domain, warning and marking results when more are returned (0 = no limit).")
+	retryOnEmpty := flag.Bool("retry-on-empty", false, "Also retry TXT lookups that return no records, up to --retries times with backoff.")
+	argsAsFiles := flag.Bool("args-as-files", false, "Treat positional arguments containing a path separator or glob character (*, ?, [) as files of domains to read.")
+	wildcardQuery := flag.Bool("wildcard", false, "Also query the wildcard name *.domain of every domain, reporting its records under that name.")
+	appendMeta := flag.Bool("append-metadata-columns", false, "Read --file as CSV (with a header row) or NDJSON and carry each row's other columns through to the output as metadata.")
+	noWildcard := flag.Bool("no-wildcard", false, "Drop records that come from a wildcard (implies --detect-wildcards).")
+	batchSize := flag.Int("batch-size", 0, "Look up domains in batches of this many, pausing between batches (0 for no batching).")
+	batchPause := flag.Duration("batch-pause", time.Second, "How long to pause between --batch-size batches.")
+	checkResolversFirst := flag.Bool("check-resolvers", false, "Send a test query to each resolver before the scan, report latency, and refuse to start if any fails to answer.")
+	sample := flag.Int("sample", 0, "Look up only N domains picked at random from the input (0 = all).")
+	seed := flag.Uint64("seed", 0, "Random seed for --sample, to pick the same domains on every run (0 = random).")
+	deferErrors := flag.Bool("defer-errors", false, "Hold lookup errors until every lookup is done, then print them grouped by category and sorted by domain.")
+	summary := flag.Bool("summary", false, "Output one overview line per domain: how many TXT records it publishes, the keys of the records kept, and whether it publishes SPF and DMARC.")
+	onlyErrors := flag.Bool("only-errors", false, "Output only the domains whose lookups failed, with an error category (not-found, timeout, servfail, refused, dns-error, network).")
+	hashRecords := flag.Bool("hash", false, "Output a sha256 of every TXT record each domain publishes (sorted, deduplicated, unaffected by filters) instead of the records, for cheap change detection between runs.")
+	findSharedTokens := flag.Bool("find-shared-tokens", false, "Report verification tokens (e.g. google-site-verification values) found on more than one domain, most shared first.")
+	dedupeValues := flag.Bool("dedupe-values-global", false, "Output each value found on more than one domain with the domains sharing it (e.g. a shared verification token).")
+	unparsed := flag.Bool("unparsed", false, "Output only the TXT records that yielded no key/value pair (parsed: false), to audit extraction.")
+	matrix := flag.String("matrix", "", "Comma-separated keys; output a domain-by-key matrix showing which domains have each key.")
+	recordType := flag.String("type", "TXT", "Comma-separated record types to query. Options: TXT (default), CAA, SRV, NAPTR.")
+	rawOutput := flag.Bool("raw", false, "Print the raw TXT answers (one quoted string per chunk) instead of processed output.")
+	page := flag.Bool("page", false, "Show pretty output through $PAGER (default less) when stdout is a terminal.")
+	tui := flag.Bool("tui", false, "Browse the results interactively: scroll, filter, expand domains and export the filtered view (builds with -tags tui only; prints normally when not on a terminal).")
+	head := flag.Int("head", 0, "Output only the first N result rows, after all other processing.")
+	tail := flag.Int("tail", 0, "Output only the last N result rows, after all other processing.")
+
+	// Override the default Usage function with a Typer-inspired help interface.
+	flag.Usage = func() {
+		if *noColor {
+			color.NoColor = true
+		}
+		header := color.New(color.FgCyan, color.Bold)
+		example := color.New(color.FgYellow)
+		fmt.Fprintf(os.Stderr, "\n")
+		header.Fprintf(os.Stderr, "dnxty - A DNS TXT Record Extraction Utility\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [options] domain1 [domain2 ...]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Options:\n")
+		printFlagDefaults()
+		fmt.Fprintf(os.Stderr, "\nExamples:\n")
+		example.Fprintf(os.Stderr, "  %s google.com facebook.com\n", os.Args[0])
+		example.Fprintf(os.Stderr, "  %s --file domains.txt --format json\n", os.Args[0])
+		example.Fprintf(os.Stderr, "  %s --all google.com\n", os.Args[0])
+		example.Fprintf(os.Stderr, "  %s --include-spf google.com\n", os.Args[0])
+		example.Fprintf(os.Stderr, "  %s --simple google.com\n\n", os.Args[0])
+	}
+
+	flag.Parse()
+	// The first --output takes the place of stdout; any others only get the
+	// main results.
+	outputPath := new(string)
+	if len(outputPaths) > 0 {
+		*outputPath = outputPaths[0]
+	}
+	if *tui && runTUI == nil {
+		fatalf("--tui is not available in this build; build dnxty with: go build -tags tui")
+	}
+	// Keep color's own detection of non-terminal stdout; --no-color,
+	// --plain and --output-dir only ever turn color off.
+	color.NoColor = color.NoColor || *noColor || *plain || *outputDir != ""
+	if *plain {
+		output = &ansiStripper{w: output}
+	}
+
+	// With --cpuprofile and --memprofile, profile the run. Both profiles are
+	// finalized however dnxty exits, including on fatal errors and Ctrl-C.
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			fatalf("Error creating CPU profile %s: %v", *cpuProfile, err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			fatalf("Error starting CPU profile: %v", err)
+		}
+		atExit(func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	if *memProfile != "" {
+		atExit(func() {
+			if err := writeHeapProfile(*memProfile); err != nil {
+				logf(errorColor, "Error writing memory profile %s: %v", *memProfile, err)
+			}
+		})
+	}
+
+	if socks5Proxy != "" {
+		if _, _, err := net.SplitHostPort(socks5Proxy); err != nil {
+			fatalf("Invalid --socks5 address %q: expected host:port", socks5Proxy)
+		}
+	}
+
+	// Default the --dns port once, before any concurrent lookups use it.
+	if dnsServer != "" {
+		dnsServer = withDNSPort(dnsServer)
+	}
+
+	if *resolvConf != "" {
+		if dnsServer != "" || *resolverList != "" {
+			fatalf("--use-resolv-conf cannot be used with --dns or --resolvers")
+		}
+		servers, err := resolvConfServers(*resolvConf)
+		if err != nil {
+			fatalf("Error reading %s: %v", *resolvConf, err)
+		}
+		resolvers.servers = servers
+	}
+	if *resolverList != "" {
+		if dnsServer != "" {
+			fatalf("--dns and --resolvers cannot be used together")
+		}
+		for _, server := range strings.Split(*resolverList, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				resolvers.servers = append(resolvers.servers, withDNSPort(server))
+			}
+		}
+	}
+	switch *resolverStrategy {
+	case "round-robin", "first", "fastest", "all":
+		resolvers.strategy = *resolverStrategy
+	default:
+		fatalf("Unknown resolver strategy '%s'. Options: round-robin, first, fastest, all.", *resolverStrategy)
+	}
+	if *normalizeSPF || *compressValues || *normalizeUnicode {
+		showRaw = true
+	}
+	if *disagreements && (resolvers.strategy != "all" || len(resolvers.servers) < 2) {
+		fatalf("--disagreements requires --resolver-strategy all with at least two --resolvers")
+	}
+	if *rotateOnError < 0 || *rotateCooldown < 0 {
+		fatalf("--resolver-rotate-on-error and --resolver-cooldown must not be negative")
+	}
+	if *rotateOnError > 0 {
+		if len(resolvers.servers) < 2 {
+			fatalf("--resolver-rotate-on-error requires at least two --resolvers")
+		}
+		resolvers.rotateAfter = *rotateOnError
+		resolvers.cooldown = *rotateCooldown
+		resolvers.streaks = make([]int, len(resolvers.servers))
+		resolvers.droppedAt = make([]time.Time, len(resolvers.servers))
+	}
+	if *perResolver < 0 {
+		fatalf("--concurrency-per-resolver must not be negative")
+	}
+	if *perResolver > 0 {
+		if len(resolvers.servers) == 0 {
+			fatalf("--concurrency-per-resolver requires --resolvers")
+		}
+		for range resolvers.servers {
+			resolvers.slots = append(resolvers.slots, make(chan struct{}, *perResolver))
+		}
+	}
+
+	// Resolve --format auto: a table for people at a terminal, NDJSON for
+	// pipes and files.
+	*outputFormat = strings.ToLower(*outputFormat)
+	formatSet := *outputFormat != "auto"
+	if *outputFormat == "auto" {
+		if *outputPath == "" && *outputDir == "" && isatty.IsTerminal(os.Stdout.Fd()) {
+			*outputFormat = "pretty"
+		} else {
+			*outputFormat = "ndjson"
+		}
+	}
+	// Every --output file is written in the format its extension implies,
+	// falling back to --format for other extensions.
+	if *outputPath != "" {
+		inferred := formatForPath(*outputPath, *outputFormat)
+		if formatSet && inferred != *outputFormat {
+			logf(warnColor, "Writing %s as %s, the format its extension implies, instead of --format %s.", *outputPath, inferred, *outputFormat)
+		}
+		*outputFormat = inferred
+	}
+
+	// With --benchmark, measure the configured resolver and stop.
+	if *benchmark {
+		results := runBenchmark()
+		var rows [][]string
+		for _, res := range results {
+			rows = append(rows, []string{res.Resolver, fmt.Sprint(res.Queries), fmt.Sprint(res.Succeeded),
+				fmt.Sprintf("%.1f%%", res.SuccessRate*100), fmt.Sprintf("%.1fms", res.AvgLatencyMs)})
+		}
+		printRows(*outputFormat, []string{"Resolver", "Queries", "Succeeded", "Success Rate", "Avg Latency"}, rows, results)
+		return
+	}
+
+	// Gather domains from file (if provided) and from positional arguments.
+	var domains []string
+	// metas holds the input metadata of each domain, parallel to domains.
+	var metas []map[string]string
+	if *filePath != "" && (*appendMeta || isJSONInput(*filePath)) {
+		var err error
+		domains, metas, err = readInputRows(*filePath, *strictInput)
+		if err != nil {
+			fatalf("Error reading file %s: %v", *filePath, err)
+		}
+		if !*appendMeta {
+			metas = nil
+		}
+	} else if *filePath != "" {
+		fileDomains, err := readDomainFile(*filePath)
+		if err != nil {
+			fatalf("Error reading file %s: %v", *filePath, err)
+		}
+		domains = fileDomains
+	}
+	// Append any domains listed at --input-url.
+	if *inputURL != "" {
+		if listTimeout <= 0 {
+			fatalf("--list-timeout must be positive")
+		}
+		urlDomains, err := fetchDomainList(*inputURL)
+		if err != nil {
+			fatalf("Error fetching domain list from %s: %v", *inputURL, err)
+		}
+		if verbose {
+			log.Printf("Read %d domains from %s", len(urlDomains), *inputURL)
+		}
+		domains = append(domains, urlDomains...)
+	}
+	// Append any domains provided as positional arguments. With
+	// --args-as-files, arguments that look like paths or globs are read as
+	// domain files instead.
+	for _, arg := range flag.Args() {
+		if !*argsAsFiles || !strings.ContainsAny(arg, "/\\*?[") {
+			domains = append(domains, arg)
+			continue
+		}
+		paths, err := filepath.Glob(arg)
+		if err != nil {
+			fatalf("Invalid file pattern %q: %v", arg, err)
+		}
+		if len(paths) == 0 {
+			logf(warnColor, "No files match %s", arg)
+		}
+		for _, path := range paths {
+			fileDomains, err := readDomainFile(path)
+			if err != nil {
+				fatalf("Error reading file %s: %v", path, err)
+			}
+			if verbose {
+				log.Printf("Read %d domains from %s", len(fileDomains), path)
+			}
+			domains = append(domains, fileDomains...)
+		}
+	}
+	metas = append(metas, make([]map[string]string, len(domains)-len(metas))...)
+	if len(domains) == 0 {
+		logf(warnColor, "No domains provided. Please supply domains as arguments or via the --file flag.\n")
+		flushPrinter()
+		flag.Usage()
+		exit(1)
+	}
+
+	// Reduce pasted URLs, host:port pairs and email addresses to hostnames.
+	if *sanitize {
+		var clean []string
+		var cleanMetas []map[string]string
+		for i, domain := range domains {
+			host, err := sanitizeDomain(domain)
+			if err != nil {
+				logf(warnColor, "Skipping input %q: %v", domain, err)
+				continue
+			}
+			if verbose && host != domain {
+				log.Printf("Sanitized input %q to %s", domain, host)
+			}
+			clean = append(clean, host)
+			cleanMetas = append(cleanMetas, metas[i])
+		}
+		domains, metas = clean, cleanMetas
+	}
+
+	// Prepend the --prefix label (e.g. "_dmarc") to every domain.
+	if p := strings.Trim(*prefix, "."); p != "" {
+		for i, domain := range domains {
+			domains[i] = p + "." + domain
+		}
+	}
+
+	// DNS names are case-insensitive, so look up lowercased, de-duplicated
+	// domains and remember the original spelling for --preserve-case.
+	displayNames := make(map[string]string)
+	inputMeta := make(map[string]map[string]string)
+	var normalized []string
+	for i, domain := range domains {
+		lower := strings.ToLower(domain)
+		if _, ok := displayNames[lower]; ok {
+			continue
+		}
+		displayNames[lower] = domain
+		inputMeta[lower] = metas[i]
+		normalized = append(normalized, lower)
+	}
+	domains = normalized
+
+	// Apply the --include-file allowlist and --exclude-file denylist.
+	if *includeFile != "" || *excludeFile != "" {
+		readList := func(path string) map[string]bool {
+			if path == "" {
+				return nil
+			}
+			list, err := readDomainFile(path)
+			if err != nil {
+				fatalf("Error reading file %s: %v", path, err)
+			}
+			set := make(map[string]bool)
+			for _, d := range list {
+				set[strings.TrimSuffix(strings.ToLower(d), ".")] = true
+			}
+			return set
+		}
+		include, exclude := readList(*includeFile), readList(*excludeFile)
+		var kept []string
+		for _, domain := range domains {
+			if include != nil && !domainListed(include, domain, *listSubdomains) {
+				continue
+			}
+			if domainListed(exclude, domain, *listSubdomains) {
+				if verbose {
+					log.Printf("Skipping %s (--exclude-file)", domain)
+				}
+				continue
+			}
+			kept = append(kept, domain)
+		}
+		if verbose {
+			log.Printf("Kept %d of %d domains after --include-file/--exclude-file", len(kept), len(domains))
+		}
+		domains = kept
+	}
+
+	// With --sample, look up a random subset of the domains, kept in input
+	// order.
+	if *sample < 0 {
+		fatalf("--sample must not be negative")
+	}
+	if *sample > 0 && *sample < len(domains) {
+		rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		if *seed != 0 {
+			rng = rand.New(rand.NewPCG(*seed, *seed))
+		}
+		picked := rng.Perm(len(domains))[:*sample]
+		sort.Ints(picked)
+		sampled := make([]string, len(picked))
+		for i, j := range picked {
+			sampled[i] = domains[j]
+		}
+		if verbose {
+			log.Printf("Sampled %d of %d domains", len(sampled), len(domains))
+		}
+		domains = sampled
+	}
+
+	if *envelope {
+		resolver := "system"
+		if dnsServer != "" {
+			resolver = dnsServer
+		} else if len(resolvers.servers) > 0 {
+			resolver = strings.Join(resolvers.servers, ",")
+		}
+		envelopeMeta = &EnvelopeMeta{
+			GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+			Resolver:    resolver,
+			DomainCount: len(domains),
+			Version:     version(),
+		}
+	}
+
+	// With --wildcard, query each domain's wildcard name right after it.
+	if *wildcardQuery {
+		var expanded []string
+		for _, domain := range domains {
+			wildcard := "*." + domain
+			displayNames[wildcard] = "*." + displayNames[domain]
+			inputMeta[wildcard] = inputMeta[domain]
+			expanded = append(expanded, domain, wildcard)
+		}
+		domains = expanded
+	}
+
+	// With --concurrency auto, start from a CPU-based default that the
+	// main lookups then adapt; other parallel work uses the starting value.
+	var concurrency int
+	var adaptive *adaptiveConcurrency
+	if *concurrencyArg == "auto" {
+		adaptive = newAdaptiveConcurrency(runtime.NumCPU())
+		concurrency = adaptive.limit
+		if verbose {
+			log.Printf("Concurrency auto: starting at %d (range %d-%d)", adaptive.limit, adaptive.min, adaptive.max)
+		}
+	} else {
+		n, err := strconv.Atoi(*concurrencyArg)
+		if err != nil || n < 1 {
+			fatalf("--concurrency must be at least 1 or auto")
+		}
+		concurrency = n
+	}
+	budget.remaining = *retryBudgetSize
+
+	if *minSuccess < 0 || *minSuccess > 1 {
+		fatalf("--min-success must be between 0 and 1")
+	}
+
+	switch simplifyMode {
+	case "prefix", "suffix", "full":
+	default:
+		fatalf("Unknown simplify mode '%s'. Options: prefix, suffix, full.", simplifyMode)
+	}
+	if keyDelimiter == "" {
+		fatalf("--key-delimiter must not be empty")
+	}
+
+	if *normalizeSPF && !*includeSPF {
+		fatalf("--normalize-spf requires --include-spf")
+	}
+
+	if *expandRefs < 0 {
+		fatalf("--expand-references must not be negative")
+	}
+
+	if *batchSize < 0 || *batchPause < 0 {
+		fatalf("--batch-size and --batch-pause must not be negative")
+	}
+
+	if *head < 0 || *tail < 0 {
+		fatalf("--head and --tail must not be negative")
+	}
+
+	if *jsonShape != "array" && *jsonShape != "map" {
+		fatalf("Unknown JSON shape '%s'. Options: array, map.", *jsonShape)
+	}
+
+	if simpleCount && !*simple {
+		fatalf("--simple-count requires --simple")
+	}
+	if *flattenJSON && (*simple || *groupRecordsFlag || *jsonShape == "map") {
+		fatalf("--flatten-json cannot be combined with --simple, --group-records or --json-shape map")
+	}
+	if *groupRecordsFlag && (*simple || *jsonShape == "map") {
+		fatalf("--group-records cannot be combined with --simple or --json-shape map")
+	}
+
+	recordTypes, err := parseRecordTypes(*recordType)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	if verbose && dnsServer != "" {
+		log.Printf("Using DNS server: %s", dnsServer)
+	}
+	if verbose && len(resolvers.servers) > 0 {
+		log.Printf("Using DNS servers: %s", strings.Join(resolvers.servers, ", "))
+	}
+
+	// With --check-resolvers, make sure every resolver answers before a
+	// long scan is spent against a dead one.
+	if *checkResolversFirst {
+		if failed := checkResolvers(); failed > 0 {
+			fatalf("%d resolver(s) failed the health check; not starting", failed)
+		}
+	}
+
+	// Compress --output with gzip when asked to or when it ends in .gz.
+	compressOutput := *outputPath != "" && (*gzipOutput || strings.HasSuffix(*outputPath, ".gz"))
+
+	// With --skip-existing, reuse results already written to the output file
+	// and only query the domains that are missing from it.
+	var existing []DomainTXT
+	if *skipExisting {
+		if *outputPath == "" {
+			fatalf("--skip-existing requires --output")
+		}
+		existing, err = loadExistingResults(*outputPath, *outputFormat, compressOutput)
+		if err != nil {
+			fatalf("Error reading existing output %s: %v", *outputPath, err)
+		}
+		done := make(map[string]bool)
+		for _, res := range existing {
+			done[strings.ToLower(res.Domain)] = true
+		}
+		var remaining []string
+		for _, domain := range domains {
+			if done[domain] {
+				if verbose {
+					log.Printf("Skipping %s: already present in %s", domain, *outputPath)
+				}
+				continue
+			}
+			remaining = append(remaining, domain)
+		}
+		domains = remaining
+	}
+
+	if *outputPath != "" {
+		f, err := createOutput(*outputPath, *skipExisting)
+		if err != nil {
+			fatalf("Error creating output file %s: %v", *outputPath, err)
+		}
+		atExit(func() {
+			f.Close()
+			if f.Name() != *outputPath {
+				commitOutput(f.Name(), *outputPath)
+			}
+		})
+		output = f
+		if compressOutput {
+			gz := gzip.NewWriter(f)
+			atExit(func() { gz.Close() })
+			output = gz
+			if lineBuffered {
+				output = &flushWriter{w: gz, flush: gz.Flush}
+			}
+		}
+		if *plain {
+			output = &ansiStripper{w: output}
+		}
+		color.NoColor = true
+	}
+
+	// With --raw, dump the TXT answers exactly as returned and stop.
+	if *rawOutput {
+		for _, domain := range domains {
+			if err := printRawTXT(domain); err != nil {
+				logf(errorColor, "Error querying TXT records for %s: %v", domain, err)
+			}
+		}
+		return
+	}
+
+	// With --parse-spf, output the SPF include tree of each domain and stop.
+	if *parseSPF {
+		var trees []SPFTree
+		for _, domain := range domains {
+			trees = append(trees, buildSPFTree(domain))
+		}
+		printSPFTrees(*outputFormat, trees)
+		return
+	}
+
+	// With --explain-spf-limit, count the DNS lookups of each domain's SPF
+	// tree, warn about those over the limit, and stop.
+	if *explainSPFLimit {
+		var counts []SPFLookupCount
+		var rows [][]string
+		for _, domain := range domains {
+			count := countSPFLookups(domain)
+			counts = append(counts, count)
+			if count.Exceeded {
+				logf(warnColor, "Warning: %s: SPF needs %d DNS lookups, over the limit of %d; receivers will fail it with a permerror\n", domain, count.Lookups, spfLookupLimit)
+			}
+			rows = append(rows, []string{count.Domain, fmt.Sprint(count.Lookups), map[bool]string{true: "yes", false: "no"}[count.Exceeded], formatBreakdown(count.Breakdown), count.Error})
+		}
+		printRows(*outputFormat, []string{"Domain", "Lookups", "Exceeded", "Breakdown", "Error"}, rows, counts)
+		return
+	}
+
+	// With --validate-spf, lint each domain's SPF records, output one row
+	// per finding and stop.
+	if *validateSPFFlag {
+		var validations []SPFValidation
+		var rows [][]string
+		for _, domain := range domains {
+			v := validateSPF(domain)
+			validations = append(validations, v)
+			rows = appendFindingRows(rows, v.Domain, v.Error, v.Findings)
+		}
+		printRows(*outputFormat, []string{"Domain", "Severity", "Term", "Finding"}, rows, validations)
+		return
+	}
+
+	// With --validate-dmarc, lint each domain's DMARC record, output one row
+	// per finding and stop.
+	if *validateDMARCFlag {
+		validations := make([]DMARCValidation, len(domains))
+		forEachDomain(domains, concurrency, func(i int, domain string) {
+			validations[i] = validateDMARC(domain)
+		})
+		var rows [][]string
+		for _, v := range validations {
+			rows = appendFindingRows(rows, v.Domain, v.Error, v.Findings)
+		}
+		printRows(*outputFormat, []string{"Domain", "Severity", "Term", "Finding"}, rows, validations)
+		return
+	}
+
+	// With --spf-summary, output one SPF posture line per domain and stop.
+	if *spfSummary {
+		var summaries []SPFSummary
+		var rows [][]string
+		for _, domain := range domains {
+			sum := summarizeSPF(domain)
+			summaries = append(summaries, sum)
+			hasSPF := "no"
+			if sum.HasSPF {
+				hasSPF = "yes"
+			}
+			rows = append(rows, []string{sum.Domain, hasSPF, sum.All, fmt.Sprint(sum.Includes), sum.Error})
+		}
+		printRows(*outputFormat, []string{"Domain", "SPF", "All", "Includes", "Error"}, rows, summaries)
+		return
+	}
+
+	// Prepare to store full results, starting from any reused ones.
+	results := existing
+
+	// With --include-empty-value, "key=" yields a pair with an empty value
+	// instead of no match (which only --all would keep, without a key).
+	pairPattern := keyValue
+	if *includeEmpty {
+		pairPattern = keyValueOrEmpty
+	}
+
+	// With --summary and --hash, keep the TXT records each domain publishes
+	// before --include-spf, --all and the other filters drop any of them.
+	var publishedMu sync.Mutex
+	published := make(map[string][]string)
+
+	// reportLookupError logs a failed lookup, or with --defer-errors keeps
+	// it for the summary printed once every lookup is done.
+	var deferredMu sync.Mutex
+	var deferred []LookupError
+	reportLookupError := func(domain, recordType string, err error) {
+		if !*deferErrors {
+			logf(errorColor, "Error looking up %s records for %s: %v", recordType, domain, err)
+			return
+		}
+		deferredMu.Lock()
+		defer deferredMu.Unlock()
+		deferred = append(deferred, LookupError{Domain: domain, Type: recordType, Category: errorCategory(err), Err: err})
+	}
+
+	// lookupDomain looks up every requested record type for domain and
+	// extracts the results, returning the first failed lookup's error.
+	lookupDomain := func(domain string) (domainResults []DomainTXT, lookupErr error) {
+		for _, recordType := range recordTypes {
+			if recordType != "TXT" {
+				var records []DomainTXT
+				err := withRetries(func() (err error) {
+					records, err = recordLookups[recordType](domain)
+					return err
+				})
+				if err != nil {
+					reportLookupError(domain, recordType, err)
+					if lookupErr == nil {
+						lookupErr = err
+					}
+					continue
+				}
+				if *firstOnly && len(records) > 0 {
+					return append(domainResults, records[0]), lookupErr
+				}
+				domainResults = append(domainResults, records...)
+				continue
+			}
+			var txtRecords []string
+			var segments [][]string
+			var chain []string
+			// With --retry-on-empty, an empty answer is retried like an
+			// error; emptyErr keeps what the last empty lookup returned.
+			var emptyErr error
+			attempts := 0
+			err := withRetries(func() (err error) {
+				attempts++
+				if chunkInfo || includeCNAME || *compatDig {
+					txtRecords, segments, chain, err = lookupTXTChunks(domain)
+				} else {
+					txtRecords, err = lookupTXTRecords(domain)
+				}
+				if *retryOnEmpty && (err == nil && len(txtRecords) == 0 || isNotFound(err)) {
+					emptyErr = err
+					return errEmptyAnswer
+				}
+				return err
+			})
+			if err == errEmptyAnswer {
+				err = emptyErr
+			}
+			if verbose && *retryOnEmpty && attempts > 1 {
+				if len(txtRecords) > 0 {
+					log.Printf("TXT records for %s appeared after %d empty answers (transient)", domain, attempts-1)
+				} else if err == nil || isNotFound(err) {
+					log.Printf("TXT records for %s still empty after %d attempts (genuinely empty)", domain, attempts)
+				}
+			}
+			if err != nil {
+				// Most domains publish no wildcard; that is not a failure.
+				if strings.HasPrefix(domain, "*.") && isNotFound(err) {
+					if verbose {
+						log.Printf("No wildcard TXT records at %s", domain)
+					}
+					continue
+				}
+				reportLookupError(domain, "TXT", err)
+				if lookupErr == nil {
+					lookupErr = err
+				}
+				continue
+			}
+			if *summary || *hashRecords {
+				publishedMu.Lock()
+				published[domain] = txtRecords
+				publishedMu.Unlock()
+			}
+			// Guard against pathological domains publishing huge record sets.
+			truncatedFrom := 0
+			if *maxTXTPerQuery > 0 && len(txtRecords) > *maxTXTPerQuery {
+				truncatedFrom = len(txtRecords)
+				logf(warnColor, "%s returned %d TXT records; keeping the first %d (--max-txt-per-query)", domain, truncatedFrom, *maxTXTPerQuery)
+				txtRecords = txtRecords[:*maxTXTPerQuery]
+			}
+			// Records a random sibling name also gets come from a wildcard.
+			var wildcard map[string]bool
+			if detectWildcards || *noWildcard {
+				wildcard = wildcardTXT(domain)
+			}
+			// Process each TXT record.
+			for i, txt := range txtRecords {
+				// By default, ignore SPF records (those starting with "v=spf1") unless --include-spf is set.
+				if !*includeSPF && isSPF(txt) {
+					explainRecord(domain, txt, "dropped (spf-filtered)")
+					continue
+				}
+				if wildcard[txt] && *noWildcard {
+					explainRecord(domain, txt, "dropped (wildcard)")
+					continue
+				}
+				raw := ""
+				// With --normalize-unicode, records are put in NFC so that
+				// composed and decomposed forms of the same text compare equal.
+				if *normalizeUnicode {
+					if nfc := norm.NFC.String(txt); nfc != txt {
+						raw, txt = txt, nfc
+					}
+				}
+				// With --compress-values, runs of whitespace become single
+				// spaces so cosmetically different records compare equal.
+				if *compressValues {
+					if compressed := strings.Join(strings.Fields(txt), " "); compressed != txt {
+						if raw == "" {
+							raw = txt
+						}
+						txt = compressed
+					}
+				}
+				if *normalizeSPF && isSPF(txt) {
+					if normalized := canonicalSPF(txt, *sortSPF); normalized != txt {
+						if raw == "" {
+							raw = txt
+						}
+						txt = normalized
+					}
+				}
+				// Capture every key=value pair in the record, one row per pair.
+				var pairs [][2]string
+				for _, match := range pairPattern.FindAllStringSubmatch(txt, -1) {
+					pairs = append(pairs, [2]string{match[1], match[2]})
+				}
+				if len(pairs) == 0 && *simple {
+					// If no key=value pattern is found and in simple mode,
+					// if the TXT record is a single word (no spaces or "="), use the entire record as the key.
+					if !strings.Contains(txt, " ") && !strings.Contains(txt, "=") {
+						pairs = append(pairs, [2]string{txt, ""})
+					}
+				}
+				// With --unparsed, keep only the records extraction missed.
+				if *unparsed {
+					if len(pairs) > 0 {
+						explainRecord(domain, txt, "dropped (parsed, --unparsed)")
+						continue
+					}
+					explainRecord(domain, txt, "kept (unparsed)")
+					res := DomainTXT{Domain: domain, TXT: txt, TruncatedFrom: truncatedFrom}
+					if *compatDig {
+						res.Dig, res.segments = digTXT(segments[i]), segments[i]
+					}
+					domainResults = append(domainResults, res)
+					continue
+				}
+				if len(pairs) == 0 {
+					// If not in allRecords mode and there is no key, skip this record.
+					if !*allRecords {
+						explainRecord(domain, txt, "dropped (regex-no-match)")
+						continue
+					}
+					explainRecord(domain, txt, "kept (no key/value, --all)")
+					pairs = append(pairs, [2]string{"", ""})
+				} else if len(pairs) > 1 {
+					explainRecord(domain, txt, fmt.Sprintf("kept (%d pairs)", len(pairs)))
+				} else {
+					explainRecord(domain, txt, "kept")
+				}
+				for _, pair := range pairs {
+					res := DomainTXT{
+						Domain: domain,
+						TXT:    txt,
+						Key:    pair[0],
+						Value:  pair[1],
+
+						TruncatedFrom: truncatedFrom,
+					}
+					if chunkInfo {
+						res.Chunks = len(segments[i])
+					}
+					if *compatDig {
+						res.Dig, res.segments = digTXT(segments[i]), segments[i]
+					}
+					if includeCNAME {
+						res.CNAMEChain = chain
+					}
+					res.Wildcard = wildcard[res.TXT]
+					if raw != "" {
+						res.Wildcard = wildcard[raw]
+						res.Raw = raw
+					}
+					domainResults = append(domainResults, res)
+				}
+				// With --first-only, the first qualifying record is enough.
+				if *firstOnly {
+					return domainResults, lookupErr
+				}
+			}
+		}
+		return domainResults, lookupErr
+	}
+
+	// --dedupe-values-global and --find-shared-tokens compare values
+	// unmasked and mask only what they print, so distinct values that mask
+	// alike are not merged.
+	if redact && !*dedupeValues && !*findSharedTokens {
+		RegisterPostProcessor(redactResult)
+	}
+	if runTag != "" {
+		RegisterPostProcessor(func(r DomainTXT) (DomainTXT, bool) {
+			r.Tag = runTag
+			return r, true
+		})
+	}
+
+	perDomain := make([][]DomainTXT, len(domains))
+	domainErrs := make([]error, len(domains))
+	elapsed := make([]time.Duration, len(domains))
+
+	// finishDomain completes the results of domains[i] once its lookups
+	// are done, adding input metadata, timing and resolver disagreements.
+	finishDomain := func(i int) {
+		domain := domains[i]
+		for j := range perDomain[i] {
+			perDomain[i][j].Meta = inputMeta[domain]
+			if showTiming {
+				perDomain[i][j].LookupMs = float64(elapsed[i].Microseconds()) / 1000
+			}
+			rrType := perDomain[i][j].Type
+			if rrType == "" {
+				rrType = "TXT"
+			}
+			if answers := resolvers.disagreement(rrType, domain); answers != nil {
+				perDomain[i][j].Disagreement = true
+				perDomain[i][j].ResolverAnswers = answers
+			}
+		}
+	}
+
+	// With CSV output and nothing that needs every result first, stream:
+	// the header is written now and a printer goroutine writes each
+	// domain's rows once it and every domain before it are looked up.
+	streamCSV := *outputFormat == "csv" && !*simple && !*uniqDomains && !*groupRecordsFlag &&
+		!*mergeVals && *head == 0 && *tail == 0 && *expandRefs == 0 && *outputDir == "" && !*tui &&
+		!*unparsed && !*dedupeValues && !*summary && !*findSharedTokens && !*hashRecords &&
+		*matrix == "" && !*disagreements && !*onlyErrors
+	lookedUp := make(chan int, len(domains))
+	streamDone := make(chan struct{})
+	if streamCSV {
+		go streamCSVRows(domains, inputMeta, existing, lookedUp, streamDone, func(i int) []DomainTXT {
+			finishDomain(i)
+			if *preserveCase {
+				for j := range perDomain[i] {
+					perDomain[i][j].Domain = displayNames[perDomain[i][j].Domain]
+				}
+			}
+			perDomain[i] = applyPostProcessors(perDomain[i])
+			return perDomain[i]
+		})
+	} else {
+		close(streamDone)
+	}
+
+	// Look up the domains, up to --concurrency at a time, keeping results in
+	// input order.
+	// With --batch-size, pause for --batch-pause between batches.
+	size := len(domains)
+	if *batchSize > 0 {
+		size = *batchSize
+	}
+	for start := 0; start < len(domains); start += size {
+		end := min(start+size, len(domains))
+		if start > 0 {
+			if verbose {
+				log.Printf("Batch done (%d/%d domains); pausing %v", start, len(domains), *batchPause)
+			}
+			time.Sleep(*batchPause)
+		}
+		lookup := func(i int, domain string) {
+			if streamCSV {
+				defer func() { lookedUp <- start + i }()
+			}
+			lookupStart := time.Now()
+			perDomain[start+i], domainErrs[start+i] = lookupDomain(domain)
+			elapsed[start+i] = time.Since(lookupStart)
+			if showAuthority && len(perDomain[start+i]) > 0 {
+				zone, ns, err := zoneAuthority(domain)
+				if err != nil {
+					logf(warnColor, "Could not find the nameservers of %s: %v", domain, err)
+				}
+				for j := range perDomain[start+i] {
+					perDomain[start+i][j].Zone = zone
+					perDomain[start+i][j].Authority = ns
+				}
+			}
+			if detectParked {
+				if provider := parkingProvider(domain); provider != "" {
+					if verbose {
+						log.Printf("%s looks parked with %s", domain, provider)
+					}
+					for j := range perDomain[start+i] {
+						perDomain[start+i][j].Parked = true
+						perDomain[start+i][j].ParkedWith = provider
+					}
+				}
+			}
+		}
+		if adaptive == nil {
+			forEachDomain(domains[start:end], concurrency, lookup)
+			continue
+		}
+		// Missing domains are an answer, not a sign of resolver strain.
+		adaptive.forEach(domains[start:end], func(i int, domain string) bool {
+			lookup(i, domain)
+			err := domainErrs[start+i]
+			return err != nil && errorCategory(err) != "not-found"
+		})
+	}
+	close(lookedUp)
+	<-streamDone
+	if *deferErrors {
+		printErrorSummary(deferred)
+	}
+	// Count domains with at least one failed lookup for --min-success.
+	failed := 0
+	for i := range domains {
+		if !streamCSV {
+			finishDomain(i)
+		}
+		results = append(results, perDomain[i]...)
+		if domainErrs[i] != nil {
+			failed++
+		}
+	}
+	if *showStats {
+		defer stats.print(len(domains))
+	}
+
+	// With --expand-references, resolve the domains the records refer to.
+	if *expandRefs > 0 {
+		expandReferences(results[len(existing):], *expandRefs, concurrency)
+	}
+
+	if *preserveCase && !streamCSV {
+		for i := len(existing); i < len(results); i++ {
+			results[i].Domain = displayNames[results[i].Domain]
+		}
+	}
+
+	if len(domains) > 0 {
+		ratio := float64(len(domains)-failed) / float64(len(domains))
+		if ratio < *minSuccess {
+			logf(nil, "Success ratio %.2f (%d/%d domains) is below --min-success %.2f",
+				ratio, len(domains)-failed, len(domains), *minSuccess)
+			exitCode = 1
+		} else if verbose {
+			log.Printf("Success ratio: %.2f (%d/%d domains)", ratio, len(domains)-failed, len(domains))
+		}
+	}
+
+	// With --disagreements, output what each resolver returned for the
+	// lookups the resolvers disagreed on and stop.
+	if *disagreements {
+		var report []Disagreement
+		var rows [][]string
+		for _, domain := range domains {
+			for _, rrType := range recordTypes {
+				answers := resolvers.disagreement(rrType, domain)
+				if answers == nil {
+					continue
+				}
+				display := domain
+				if *preserveCase {
+					display = displayNames[domain]
+				}
+				report = append(report, Disagreement{Domain: display, Type: rrType, Answers: answers})
+				answers = slices.Clone(answers)
+				for i, a := range answers {
+					a.Records = maskRecords(a.Records)
+					answers[i] = a
+					records := strings.Join(a.Records, "\n")
+					if a.NotFound {
+						records = "(not found)"
+					}
+					rows = append(rows, []string{display, rrType, a.Resolver, records})
+				}
+			}
+		}
+		printRows(*outputFormat, []string{"Domain", "Type", "Resolver", "Records"}, rows, report)
+		return
+	}
+
+	// With --only-errors, output just the domains whose lookups failed and
+	// stop.
+	if *onlyErrors {
+		var domainErrors []DomainError
+		var rows [][]string
+		for i, domain := range domains {
+			if domainErrs[i] == nil {
+				continue
+			}
+			if *preserveCase {
+				domain = displayNames[domain]
+			}
+			de := DomainError{Domain: domain, Category: errorCategory(domainErrs[i]), Error: domainErrs[i].Error()}
+			domainErrors = append(domainErrors, de)
+			rows = append(rows, []string{de.Domain, de.Category, de.Error})
+		}
+		printRows(*outputFormat, []string{"Domain", "Category", "Error"}, rows, domainErrors)
+		return
+	}
+
+	// Run freshly looked-up results through the registered post-processors;
+	// reused results were already processed by the run that wrote them, and
+	// streamed results as they were written.
+	if !streamCSV {
+		results = append(results[:len(existing)], applyPostProcessors(results[len(existing):])...)
+	}
+
+	if *mergeVals {
+		results = mergeValues(results, *mergeSep)
+	}
+
+	// With --unparsed, output the records that yielded no key/value and stop.
+	if *unparsed {
+		var records []UnparsedRecord
+		var rows [][]string
+		for _, res := range results {
+			if res.Type != "" {
+				continue
+			}
+			records = append(records, UnparsedRecord{Domain: res.Domain, TXT: res.TXT})
+			rows = append(rows, []string{res.Domain, res.TXT, "false"})
+		}
+		printRows(*outputFormat, []string{"Domain", "TXT Record", "Parsed"}, rows, records)
+		return
+	}
+
+	// With --dedupe-values-global, group the domains sharing each value and stop.
+	if *dedupeValues {
+		shared := sharedValues(results)
+		var rows [][]string
+		for i, sv := range shared {
+			if redact {
+				sv.Value = redactValue(sv.Value)
+				shared[i] = sv
+			}
+			rows = append(rows, []string{sv.Value, fmt.Sprint(len(sv.Domains)), strings.Join(sv.Domains, ", ")})
+		}
+		printRows(*outputFormat, []string{"Value", "Count", "Domains"}, rows, shared)
+		return
+	}
+
+	// With --summary, output one overview line per domain and stop.
+	if *summary {
+		dmarc := make([]bool, len(domains))
+		forEachDomain(domains, concurrency, func(i int, domain string) {
+			txts, err := lookupTXTRecords("_dmarc." + domain)
+			if err != nil {
+				return
+			}
+			for _, txt := range txts {
+				if strings.HasPrefix(strings.ToLower(txt), "v=dmarc1") {
+					dmarc[i] = true
+				}
+			}
+		})
+		byDomain := make(map[string][]DomainTXT)
+		for _, r := range results {
+			lower := strings.ToLower(r.Domain)
+			byDomain[lower] = append(byDomain[lower], r)
+		}
+		yesNo := map[bool]string{true: "yes", false: "no"}
+		var summaries []DomainSummary
+		var rows [][]string
+		for i, domain := range domains {
+			sum := summarizeDomain(domain, published[domain], byDomain[domain])
+			if *preserveCase {
+				sum.Domain = displayNames[domain]
+			}
+			sum.DMARC = dmarc[i]
+			summaries = append(summaries, sum)
+			rows = append(rows, []string{sum.Domain, fmt.Sprint(sum.Records), strings.Join(sum.Keys, ", "), yesNo[sum.SPF], yesNo[sum.DMARC]})
+		}
+		printRows(*outputFormat, []string{"Domain", "Records", "Keys", "SPF", "DMARC"}, rows, summaries)
+		return
+	}
+
+	// With --find-shared-tokens, report verification tokens shared by
+	// several domains and stop.
+	if *findSharedTokens {
+		shared := sharedTokens(results)
+		var rows [][]string
+		for i, st := range shared {
+			if redact {
+				st.Token = redactValue(st.Token)
+				shared[i] = st
+			}
+			rows = append(rows, []string{st.Key, st.Token, fmt.Sprint(st.Count), strings.Join(st.Domains, ", ")})
+		}
+		printRows(*outputFormat, []string{"Key", "Token", "Count", "Domains"}, rows, shared)
+		return
+	}
+
+	// With --hash, output a fingerprint of each domain's record set and stop.
+	if *hashRecords {
+		hashes := recordSetHashes(domains, published)
+		if *preserveCase {
+			for i := range hashes {
+				hashes[i].Domain = displayNames[hashes[i].Domain]
+			}
+		}
+		var rows [][]string
+		for _, dh := range hashes {
+			rows = append(rows, []string{dh.Domain, dh.Hash})
+		}
+		printRows(*outputFormat, []string{"Domain", "Hash"}, rows, hashes)
+		return
+	}
+
+	// With --matrix, output a domain-by-key presence matrix and stop.
+	if *matrix != "" {
+		var keys []string
+		for _, k := range strings.Split(*matrix, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				keys = append(keys, k)
+			}
+		}
+		var rows []MatrixRow
+		for _, domain := range domains {
+			name := domain
+			if *preserveCase {
+				name = displayNames[domain]
+			}
+			rows = append(rows, buildMatrixRow(name, keys, results))
+		}
+		printMatrix(*outputFormat, keys, rows)
+		return
+	}
+
+	// Limit output to the first/last rows for quick previews.
+	if *head > 0 && len(results) > *head {
+		results = results[:*head]
+	}
+	if *tail > 0 && len(results) > *tail {
+		results = results[len(results)-*tail:]
+	}
+
+	// With --syslog, also send every result to the syslog collector.
+	if *syslogOn {
+		if err := sendSyslog(*syslogAddr, results); err != nil {
+			logf(errorColor, "Error sending results to syslog at %s: %v", *syslogAddr, err)
+		}
+	}
+
+	// printResults renders results in the chosen output mode and format.
+	printResults := func(format string, results []DomainTXT) {
+		// With --uniq-domains, output each domain that produced a result once.
+		if *uniqDomains {
+			var uniq []string
+			seen := make(map[string]bool)
+			var rows [][]string
+			for _, res := range results {
+				if seen[res.Domain] {
+					continue
+				}
+				seen[res.Domain] = true
+				uniq = append(uniq, res.Domain)
+				rows = append(rows, []string{res.Domain})
+			}
+			printRows(format, []string{"Domain"}, rows, uniq)
+			return
+		}
+
+		// With --group-records, output one object per domain with its
+		// records grouped by type; tables show the count per type.
+		if *groupRecordsFlag {
+			grouped := groupRecords(results)
+			var rows [][]string
+			for _, g := range grouped {
+				types := make([]string, 0, len(g.Records))
+				for rrType := range g.Records {
+					types = append(types, rrType)
+				}
+				sort.Strings(types)
+				for _, rrType := range types {
+					rows = append(rows, []string{g.Domain, rrType, fmt.Sprint(len(g.Records[rrType]))})
+				}
+			}
+			printRows(format, []string{"Domain", "Type", "Records"}, rows, grouped)
+			return
+		}
+
+		// If the --simple flag is enabled, produce simplified output.
+		if *simple {
+			// Deduplicate simplified keys per domain, keeping the order of
+			// first appearance so output is the same on every run.
+			var simpleResults []SimpleResult
+			seen := make(map[SimpleResult]int)
+			for _, res := range results {
+				if res.Key == "" {
+					explainRecord(res.Domain, res.TXT, "dropped (empty-key-skipped)")
+					continue
+				}
+				sr := SimpleResult{
+					Domain: res.Domain,
+					Key:    simplifyKey(res.Key),
+					Tag:    res.Tag,
+				}
+				i, ok := seen[sr]
+				if !ok {
+					i = len(simpleResults)
+					seen[sr] = i
+					simpleResults = append(simpleResults, sr)
+				}
+				if simpleCount {
+					simpleResults[i].Count++
+				}
+			}
+
+			// Output the simplified results in the chosen format.
+			switch strings.ToLower(format) {
+			case "pretty":
+				printSimplePretty(simpleResults)
+			case "json":
+				printSimpleJSON(simpleResults)
+			case "ndjson":
+				printNDJSON(simpleResults)
+			case "yaml":
+				printSimpleYAML(simpleResults)
+			case "csv":
+				printSimpleCSV(simpleResults)
+			case "org":
+				var rows [][]string
+				for _, r := range simpleResults {
+					rows = append(rows, r.row())
+				}
+				printOrgTable(simpleHeader(), rows)
+			case "markdown":
+				var rows [][]string
+				for _, r := range simpleResults {
+					rows = append(rows, r.row())
+				}
+				printMarkdownTable(simpleHeader(), rows)
+			default:
+				logf(warnColor, "Unknown output format '%s'. Defaulting to pretty.", format)
+				printSimplePretty(simpleResults)
+			}
+			return
+		}
+
+		// Otherwise, output the full results.
+		switch strings.ToLower(format) {
+		case "pretty":
+			printPretty(results)
+		case "json":
+			if *flattenJSON {
+				printJSONFlat(results)
+			} else if *jsonShape == "map" {
+				printJSONMap(results)
+			} else {
+				printJSON(results)
+			}
+		case "ndjson":
+			if *flattenJSON {
+				printNDJSON(flattenResults(results))
+			} else if *jsonShape == "map" {
+				printNDJSONMap(results)
+			} else {
+				printNDJSON(results)
+			}
+		case "yaml":
+			printYAML(results)
+		case "csv":
+			printCSV(results)
+		case "zone":
+			printZone(results)
+		case "org":
+			printOrg(results)
+		case "markdown":
+			printMarkdown(results)
+		default:
+			logf(warnColor, "Unknown output format '%s'. Defaulting to pretty.", format)
+			printPretty(results)
+		}
+	}
+
+	// writeOutput writes results to path in the format its extension
+	// implies, compressed with --gzip or a .gz extension and without color.
+	writeOutput := func(path string, results []DomainTXT) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		primary, noColor := output, color.NoColor
+		defer func() { output, color.NoColor = primary, noColor }()
+		output = f
+		color.NoColor = true
+		var gz *gzip.Writer
+		if *gzipOutput || strings.HasSuffix(path, ".gz") {
+			gz = gzip.NewWriter(f)
+			output = gz
+		}
+		if *plain {
+			output = &ansiStripper{w: output}
+		}
+		printResults(formatForPath(path, *outputFormat), results)
+		if gz != nil {
+			if err := gz.Close(); err != nil {
+				f.Close()
+				return err
+			}
+		}
+		return f.Close()
+	}
+
+	// With several --output files, write the results to each file after the
+	// first in the format its extension implies.
+	for _, path := range outputPaths[min(1, len(outputPaths)):] {
+		if err := writeOutput(path, results); err != nil {
+			logf(errorColor, "Error writing output file %s: %v", path, err)
+		}
+	}
+
+	// With --output-dir, write each domain's results to its own file.
+	if *outputDir != "" {
+		if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+			fatalf("Error creating output directory %s: %v", *outputDir, err)
+		}
+		var order []string
+		byDomain := make(map[string][]DomainTXT)
+		for _, res := range results {
+			if _, ok := byDomain[res.Domain]; !ok {
+				order = append(order, res.Domain)
+			}
+			byDomain[res.Domain] = append(byDomain[res.Domain], res)
+		}
+		for _, domain := range order {
+			path := filepath.Join(*outputDir, safeFilename(domain)+"."+formatExtension(*outputFormat))
+			f, err := os.Create(path)
+			if err != nil {
+				logf(errorColor, "Error creating output file %s: %v", path, err)
+				continue
+			}
+			output = f
+			if *plain {
+				output = &ansiStripper{w: f}
+			}
+			printResults(*outputFormat, byDomain[domain])
+			f.Close()
+		}
+		return
+	}
+
+	// With --tui, browse the results instead of printing them to the
+	// terminal; any --output file is still written first.
+	if *tui {
+		if isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd()) {
+			if *outputPath != "" {
+				printResults(*outputFormat, results)
+			}
+			if err := runTUI(results, writeOutput); err != nil {
+				logf(errorColor, "Error running the TUI: %v", err)
+				exitCode = 1
+			}
+			return
+		}
+		logf(warnColor, "--tui needs a terminal; printing the results instead.")
+	}
+
+	// With --page, send interactive pretty output through a pager.
+	if *page && *outputFormat == "pretty" && *outputPath == "" && isatty.IsTerminal(os.Stdout.Fd()) {
+		defer startPager()()
+		if *plain {
+			output = &ansiStripper{w: output}
+		}
+	}
+	if !streamCSV {
+		printResults(*outputFormat, results)
+	}
+}
+
+// startPager redirects output into $PAGER, or less when it is unset, and
+// returns a function that closes the pager's input and waits for it to exit.
+// If no pager can be started, output is left unchanged.
+func startPager() (wait func()) {
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		args = []string{"less"}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Like git: quit if one screen, keep colors, don't clear the screen.
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	w, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		if verbose {
+			log.Printf("Not paging output: %v", err)
+		}
+		return func() {}
+	}
+	prev := output
+	output = w
+	return func() {
+		w.Close()
+		cmd.Wait()
+		output = prev
+	}
+}
+
+// newTable returns a table writing to output, without borders or column
+// separators when --compact-pretty is set.
+func newTable() *tablewriter.Table {
+	table := tablewriter.NewWriter(output)
+	if compactPretty {
+		table.SetBorder(false)
+		table.SetHeaderLine(false)
+		table.SetColumnSeparator("")
+		table.SetCenterSeparator("")
+		table.SetRowSeparator("")
+		table.SetTablePadding("  ")
+		table.SetNoWhiteSpace(true)
+		table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+	}
+	return table
+}
+
+// tableRow returns row with every cell passed through tableCell.
+func tableRow(row []string) []string {
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		cells[i] = tableCell(cell)
+	}
+	return cells
+}
+
+// tableCell prepares s for a table, whose layout is computed from display
+// widths (wide CJK and emoji characters take two columns). Control
+// characters have no width there but do move the terminal cursor, so tabs
+// are expanded to spaces at 8-column stops and the rest are shown escaped,
+// e.g. \x1b. Line breaks are kept; the table splits cells on them.
+func tableCell(s string) string {
+	if strings.IndexFunc(s, func(r rune) bool { return r != '\n' && unicode.IsControl(r) }) < 0 {
+		return s
+	}
+	var b strings.Builder
+	col := 0
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			b.WriteRune(r)
+			col = 0
+		case r == '\t':
+			n := 8 - col%8
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		case unicode.IsControl(r):
+			esc := fmt.Sprintf("\\x%02x", r)
+			b.WriteString(esc)
+			col += len(esc)
+		default:
+			b.WriteRune(r)
+			col += runewidth.RuneWidth(r)
+		}
+	}
+	return b.String()
+}
+
+// printPretty outputs the full results as a formatted table.
+func printPretty(results []DomainTXT) {
+	table := newTable()
+	metaCols := metaColumns(results)
+	header := fullHeader(metaCols)
+	table.SetHeader(header)
+	if !color.NoColor {
+		headerColors := make([]tablewriter.Colors, len(header))
+		for i := range headerColors {
+			headerColors[i] = tablewriter.Colors{tablewriter.FgHiBlueColor, tablewriter.Bold}
+		}
+		table.SetHeaderColor(headerColors...)
+	}
+	for _, r := range results {
+		row := r.row(metaCols)
+		colors := make([]tablewriter.Colors, len(row))
+		colors[3] = valueColors(r.Value)
+		table.Rich(tableRow(row), colors)
+	}
+	table.Render()
+}
+
+// printZone outputs each record once as a BIND zone-file line, e.g.
+// `example.com. IN TXT "v=spf1 ~all"`, so results can be loaded into a test
+// nameserver. Records other than TXT are written in their presentation form.
+func printZone(results []DomainTXT) {
+	seen := make(map[string]bool)
+	for _, r := range results {
+		rrType, rdata := r.Type, r.TXT
+		if rrType == "" {
+			rrType, rdata = "TXT", zoneTXT(r.TXT)
+		}
+		line := fmt.Sprintf("%s\tIN\t%s\t%s", dns.Fqdn(r.Domain), rrType, rdata)
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		fmt.Fprintln(output, line)
+	}
+}
+
+// zoneTXT formats txt as zone-file character-strings: quoted, escaped and
+// split every 255 bytes.
+func zoneTXT(txt string) string {
+	var parts []string
+	for {
+		n := min(len(txt), txtChunkSize)
+		parts = append(parts, quoteCharString(txt[:n]))
+		if txt = txt[n:]; txt == "" {
+			return strings.Join(parts, " ")
+		}
+	}
+}
+
+// unescapeCharString reverses the presentation-form escaping of a
+// character-string: \DDD becomes the byte with that decimal value and \X
+// becomes X.
+func unescapeCharString(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			if n := int(s[i+1]-'0')*100 + int(s[i+2]-'0')*10 + int(s[i+3]-'0'); n <= 255 {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i+1])
+		i++
+	}
+	return b.String()
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digTXT formats the character-strings of a TXT record the way dig prints
+// them: each quoted and escaped, separated by spaces.
+func digTXT(segments []string) string {
+	parts := make([]string, len(segments))
+	for i, seg := range segments {
+		parts[i] = quoteCharString(seg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteCharString quotes a character-string in zone-file presentation form,
+// escaping quotes and backslashes and writing other non-printable bytes as
+// \DDD.
+func quoteCharString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03d", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// printJSON outputs the full results in JSON format with syntax highlighting.
+func printJSON(results []DomainTXT) {
+	b, err := json.MarshalIndent(wrapEnvelope(results), "", "  ")
+	if err != nil {
+		logf(errorColor, "Error marshalling JSON: %v", err)
+		return
+	}
+	jsonStr := string(b)
+	if !color.NoColor {
+		if err := quick.Highlight(output, jsonStr, "json", "terminal", "monokai"); err != nil {
+			fmt.Fprintln(output, jsonStr)
+		}
+	} else {
+		fmt.Fprintln(output, jsonStr)
+	}
+}
+
+// resultsByDomain restructures results into a map keyed by domain and then by
+// key. A key with several values maps to an array of them. Records without a
+// key are omitted.
+func resultsByDomain(results []DomainTXT) map[string]map[string]interface{} {
+	shaped := make(map[string]map[string]interface{})
+	for _, r := range results {
+		if r.Key == "" {
+			continue
+		}
+		keys := shaped[r.Domain]
+		if keys == nil {
+			keys = make(map[string]interface{})
+			shaped[r.Domain] = keys
+		}
+		switch v := keys[r.Key].(type) {
+		case nil:
+			keys[r.Key] = r.Value
+		case string:
+			keys[r.Key] = []string{v, r.Value}
+		case []string:
+			keys[r.Key] = append(v, r.Value)
+		}
+	}
+	return shaped
+}
+
+// printJSONMap outputs the full results as a JSON object keyed by domain and
+// then by key, with syntax highlighting.
+func printJSONMap(results []DomainTXT) {
+	b, err := json.MarshalIndent(wrapEnvelope(resultsByDomain(results)), "", "  ")
+	if err != nil {
+		logf(errorColor, "Error marshalling JSON: %v", err)
+		return
+	}
+	printHighlighted(string(b), "json")
+}
+
+// printNDJSONMap outputs the full results as one {"domain": {key: value}}
+// object per line, domains in the order they first appear.
+func printNDJSONMap(results []DomainTXT) {
+	shaped := resultsByDomain(results)
+	var lines []map[string]map[string]interface{}
+	for _, r := range results {
+		if keys, ok := shaped[r.Domain]; ok {
+			lines = append(lines, map[string]map[string]interface{}{r.Domain: keys})
+			delete(shaped, r.Domain)
+		}
+	}
+	printNDJSON(lines)
+}
+
+// printJSONFlat outputs the full results as a single JSON object with
+// dotted domain.key keys; see flattenResults.
+func printJSONFlat(results []DomainTXT) {
+	b, err := json.MarshalIndent(wrapEnvelope(flattenResults(results)), "", "  ")
+	if err != nil {
+		logf(errorColor, "Error marshalling JSON: %v", err)
+		return
+	}
+	printHighlighted(string(b), "json")
+}
+
+// flattenResults maps "domain.key" to each value. A key with several values
+// (or whose dotted name collides with another) gets an index appended, e.g.
+// "example.com.ms.0" and "example.com.ms.1". Records without a key are
+// omitted.
+func flattenResults(results []DomainTXT) map[string]string {
+	counts := make(map[string]int)
+	for _, r := range results {
+		if r.Key != "" {
+			counts[r.Domain+"."+r.Key]++
+		}
+	}
+	flat := make(map[string]string)
+	next := make(map[string]int)
+	for _, r := range results {
+		if r.Key == "" {
+			continue
+		}
+		base := r.Domain + "." + r.Key
+		name := base
+		_, taken := flat[name]
+		if counts[base] > 1 || taken {
+			for {
+				name = fmt.Sprintf("%s.%d", base, next[base])
+				next[base]++
+				if _, taken := flat[name]; !taken {
+					break
+				}
+			}
+		}
+		flat[name] = r.Value
+	}
+	return flat
+}
+
+// printYAML outputs the full results in YAML format with syntax highlighting.
+func printYAML(results []DomainTXT) {
+	b, err := yaml.Marshal(wrapEnvelope(results))
+	if err != nil {
+		logf(errorColor, "Error marshalling YAML: %v", err)
+		return
+	}
+	yamlStr := string(b)
+	if !color.NoColor {
+		if err := quick.Highlight(output, yamlStr, "yaml", "terminal", "monokai"); err != nil {
+			fmt.Fprintln(output, yamlStr)
+		}
+	} else {
+		fmt.Fprintln(output, yamlStr)
+	}
+}
+
+// metaColumns returns the sorted names of the input metadata columns
+// carried by results.
+func metaColumns(results []DomainTXT) []string {
+	seen := make(map[string]bool)
+	var cols []string
+	for _, r := range results {
+		for col := range r.Meta {
+			if !seen[col] {
+				seen[col] = true
+				cols = append(cols, col)
+			}
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// printCSV streams the full results to output in CSV format.
+func printCSV(results []DomainTXT) {
+	// Rows are streamed straight to output; CSV is never highlighted since
+	// escape codes would corrupt it for consumers.
+	writer := newCSVWriter()
+	metaCols := metaColumns(results)
+	if err := writer.Write(fullHeader(metaCols)); err != nil {
+		logf(errorColor, "Error writing CSV header: %v", err)
+		return
+	}
+	for _, r := range results {
+		if err := writer.Write(r.row(metaCols)); err != nil {
+			logf(errorColor, "Error writing CSV row: %v", err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logf(errorColor, "Error flushing CSV: %v", err)
+	}
+}
+
+// streamCSVRows is the printer goroutine of streamed CSV output. It writes
+// the header and the existing results, then, as the indexes of looked-up
+// domains arrive on lookedUp, the rows finish returns for each domain in
+// input order. It closes done once lookedUp is closed and every row is
+// written.
+func streamCSVRows(domains []string, inputMeta map[string]map[string]string, existing []DomainTXT, lookedUp <-chan int, done chan<- struct{}, finish func(i int) []DomainTXT) {
+	defer close(done)
+	// Every input metadata column is known up front, unlike printCSV,
+	// which only has columns carried by results.
+	seen := make(map[string]bool)
+	var metaCols []string
+	for _, r := range existing {
+		for col := range r.Meta {
+			if !seen[col] {
+				seen[col] = true
+				metaCols = append(metaCols, col)
+			}
+		}
+	}
+	for _, domain := range domains {
+		for col := range inputMeta[domain] {
+			if !seen[col] {
+				seen[col] = true
+				metaCols = append(metaCols, col)
+			}
+		}
+	}
+	sort.Strings(metaCols)
+
+	writer := newCSVWriter()
+	failed := false
+	write := func(results []DomainTXT) {
+		for _, r := range results {
+			if err := writer.Write(r.row(metaCols)); err != nil && !failed {
+				logf(errorColor, "Error writing CSV row: %v", err)
+				failed = true
+			}
+		}
+		writer.Flush()
+	}
+	if err := writer.Write(fullHeader(metaCols)); err != nil {
+		logf(errorColor, "Error writing CSV header: %v", err)
+		failed = true
+	}
+	write(existing)
+	// The output now holds every resumed result, so keep it even if the run
+	// is interrupted.
+	keepOutput.Store(true)
+
+	ready := make([]bool, len(domains))
+	next := 0
+	for i := range lookedUp {
+		ready[i] = true
+		for ; next < len(domains) && ready[next]; next++ {
+			write(finish(next))
+		}
+	}
+	if err := writer.Error(); err != nil && !failed {
+		logf(errorColor, "Error flushing CSV: %v", err)
+	}
+}
+
+// fullHeader returns the columns of full table, CSV, org and Markdown
+// output: the domain, record, key and value, the columns of the flags that
+// add them, then metaCols.
+func fullHeader(metaCols []string) []string {
+	header := []string{"Domain", "TXT Record", "Key", "Value"}
+	if showValueType {
+		header = append(header, "Value Type")
+	}
+	if showRaw {
+		header = append(header, "Raw")
+	}
+	if chunkInfo {
+		header = append(header, "Chunks")
+	}
+	if detectWildcards {
+		header = append(header, "Wildcard")
+	}
+	if detectParked {
+		header = append(header, "Parked", "Parked With")
+	}
+	if includeCNAME {
+		header = append(header, "CNAME Chain")
+	}
+	if showAuthority {
+		header = append(header, "Authority")
+	}
+	if resolvers.compares() {
+		header = append(header, "Disagreement")
+	}
+	if showTiming {
+		header = append(header, "Lookup ms")
+	}
+	if runTag != "" {
+		header = append(header, "Tag")
+	}
+	return append(header, metaCols...)
+}
+
+// row returns r's cells in fullHeader's order.
+func (r DomainTXT) row(metaCols []string) []string {
+	row := []string{r.domainColumn(), r.txtColumn(), r.Key, r.Value}
+	if showValueType {
+		row = append(row, r.ValueType)
+	}
+	if showRaw {
+		row = append(row, r.Raw)
+	}
+	if chunkInfo {
+		row = append(row, fmt.Sprint(r.Chunks))
+	}
+	if detectWildcards {
+		row = append(row, fmt.Sprint(r.Wildcard))
+	}
+	if detectParked {
+		row = append(row, fmt.Sprint(r.Parked), r.ParkedWith)
+	}
+	if includeCNAME {
+		row = append(row, strings.Join(r.CNAMEChain, " -> "))
+	}
+	if showAuthority {
+		row = append(row, strings.Join(r.Authority, ", "))
+	}
+	if resolvers.compares() {
+		row = append(row, fmt.Sprint(r.Disagreement))
+	}
+	if showTiming {
+		row = append(row, fmt.Sprintf("%.1f", r.LookupMs))
+	}
+	if runTag != "" {
+		row = append(row, r.Tag)
+	}
+	for _, col := range metaCols {
+		row = append(row, r.Meta[col])
+	}
+	return row
+}
+
+// parseRow is the inverse of row: it reads a result back from the cells of a
+// CSV row under header, as written by fullHeader. Columns it does not know
+// are metadata columns.
+func parseRow(header, row []string) (DomainTXT, error) {
+	var r DomainTXT
+	var err error
+	for i, col := range header {
+		if i >= len(row) {
+			break
+		}
+		cell := row[i]
+		switch col {
+		case "Domain":
+			r.Domain = cell
+			if m := truncatedDomain.FindStringSubmatch(cell); m != nil {
+				r.Domain = m[1]
+				r.TruncatedFrom, _ = strconv.Atoi(m[2])
+			}
+		case "TXT Record":
+			r.TXT = cell
+		case "Key":
+			r.Key = cell
+		case "Value":
+			r.Value = cell
+		case "Value Type":
+			r.ValueType = cell
+		case "Raw":
+			r.Raw = cell
+		case "Chunks":
+			r.Chunks, err = strconv.Atoi(cell)
+		case "Wildcard":
+			r.Wildcard, err = strconv.ParseBool(cell)
+		case "Parked":
+			r.Parked, err = strconv.ParseBool(cell)
+		case "Parked With":
+			r.ParkedWith = cell
+		case "CNAME Chain":
+			if cell != "" {
+				r.CNAMEChain = strings.Split(cell, " -> ")
+			}
+		case "Authority":
+			if cell != "" {
+				r.Authority = strings.Split(cell, ", ")
+			}
+		case "Disagreement":
+			r.Disagreement, err = strconv.ParseBool(cell)
+		case "Lookup ms":
+			r.LookupMs, err = strconv.ParseFloat(cell, 64)
+		case "Tag":
+			r.Tag = cell
+		default:
+			if cell != "" {
+				if r.Meta == nil {
+					r.Meta = make(map[string]string)
+				}
+				r.Meta[col] = cell
+			}
+		}
+		if err != nil {
+			return r, fmt.Errorf("column %s: %v", col, err)
+		}
+	}
+	return r, nil
+}
+
+// The following functions output simplified results.
+
+// simpleHeader returns the columns of simplified tables and CSV: the
+// domain and key, plus Count with --simple-count and Tag with --tag.
+func simpleHeader() []string {
+	header := []string{"Domain", "Key"}
+	if simpleCount {
+		header = append(header, "Count")
+	}
+	if runTag != "" {
+		header = append(header, "Tag")
+	}
+	return header
+}
+
+// row returns r's cells in simpleHeader's order.
+func (r SimpleResult) row() []string {
+	row := []string{r.Domain, r.Key}
+	if simpleCount {
+		row = append(row, fmt.Sprint(r.Count))
+	}
+	if runTag != "" {
+		row = append(row, r.Tag)
+	}
+	return row
+}
+
+func printSimplePretty(simpleResults []SimpleResult) {
+	table := newTable()
+	header := simpleHeader()
+	table.SetHeader(header)
+	headerColors := make([]tablewriter.Colors, len(header))
+	for i := range headerColors {
+		headerColors[i] = tablewriter.Colors{tablewriter.FgHiBlueColor, tablewriter.Bold}
+	}
+	if !color.NoColor {
+		table.SetHeaderColor(headerColors...)
+	}
+	for _, r := range simpleResults {
+		table.Append(tableRow(r.row()))
+	}
+	table.Render()
+}
+
+func printSimpleJSON(simpleResults []SimpleResult) {
+	b, err := json.MarshalIndent(wrapEnvelope(simpleResults), "", "  ")
+	if err != nil {
+		logf(errorColor, "Error marshalling JSON: %v", err)
+		return
+	}
+	jsonStr := string(b)
+	if !color.NoColor {
+		if err := quick.Highlight(output, jsonStr, "json", "terminal", "monokai"); err != nil {
+			fmt.Fprintln(output, jsonStr)
+		}
+	} else {
+		fmt.Fprintln(output, jsonStr)
+	}
+}
+
+func printSimpleYAML(simpleResults []SimpleResult) {
+	b, err := yaml.Marshal(wrapEnvelope(simpleResults))
+	if err != nil {
+		logf(errorColor, "Error marshalling YAML: %v", err)
+		return
+	}
+	yamlStr := string(b)
+	if !color.NoColor {
+		if err := quick.Highlight(output, yamlStr, "yaml", "terminal", "monokai"); err != nil {
+			fmt.Fprintln(output, yamlStr)
+		}
+	} else {
+		fmt.Fprintln(output, yamlStr)
+	}
+}
+
+func printSimpleCSV(simpleResults []SimpleResult) {
+	writer := newCSVWriter()
+	if err := writer.Write(simpleHeader()); err != nil {
+		logf(errorColor, "Error writing CSV header: %v", err)
+		return
+	}
+	for _, r := range simpleResults {
+		if err := writer.Write(r.row()); err != nil {
+			logf(errorColor, "Error writing CSV row: %v", err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logf(errorColor, "Error flushing CSV: %v", err)
+	}
+}
+
+// printOrg outputs the full results as an Emacs org-mode table with the
+// same columns as CSV output.
+func printOrg(results []DomainTXT) {
+	header, rows := fullTable(results)
+	printOrgTable(header, rows)
+}
+
+// printMarkdown outputs the full results as a Markdown table.
+func printMarkdown(results []DomainTXT) {
+	header, rows := fullTable(results)
+	printMarkdownTable(header, rows)
+}
+
+// fullTable returns the header and rows of results for table formats.
+func fullTable(results []DomainTXT) (header []string, rows [][]string) {
+	metaCols := metaColumns(results)
+	for _, r := range results {
+		rows = append(rows, r.row(metaCols))
+	}
+	return fullHeader(metaCols), rows
+}
+
+// printOrgTable outputs header and rows as an org-mode table, with a
+// |---+---| rule under the header. Pipes in cells are written as \vert{} and
+// line breaks as spaces, so each row stays on one line; org aligns the
+// columns itself (C-c C-c).
+func printOrgTable(header []string, rows [][]string) {
+	cell := strings.NewReplacer("|", "\\vert{}", "\r\n", " ", "\n", " ")
+	line := func(cells []string) {
+		escaped := make([]string, len(cells))
+		for i, c := range cells {
+			escaped[i] = cell.Replace(c)
+		}
+		fmt.Fprintf(output, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	line(header)
+	rule := make([]string, len(header))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	fmt.Fprintf(output, "|%s|\n", strings.Join(rule, "+"))
+	for _, row := range rows {
+		line(row)
+	}
+}
+
+// printMarkdownTable outputs header and rows as a GitHub-flavored Markdown
+// pipe table. Backslashes and pipes in cells are escaped and line breaks
+// written as <br>, so each row stays on one line.
+func printMarkdownTable(header []string, rows [][]string) {
+	cell := strings.NewReplacer("\\", "\\\\", "|", "\\|", "\r\n", "<br>", "\n", "<br>")
+	line := func(cells []string) {
+		escaped := make([]string, len(cells))
+		for i, c := range cells {
+			escaped[i] = cell.Replace(c)
+		}
+		fmt.Fprintf(output, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	line(header)
+	rule := make([]string, len(header))
+	for i := range rule {
+		rule[i] = "---"
+	}
+	line(rule)
+	for _, row := range rows {
+		line(row)
+	}
+}
+
+// readDomainFile reads one domain per line from path, skipping blank lines.
+func readDomainFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readDomains(f)
+}
+
+// fetchDomainList downloads a newline-separated domain list from url. The
+// request is bounded by --list-timeout and goes through --socks5 when set,
+// or else the proxy from the environment.
+func fetchDomainList(url string) ([]string, error) {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if socks5Proxy != "" {
+		transport = &http.Transport{DialContext: dialDNS}
+	}
+	client := &http.Client{Transport: transport, Timeout: listTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return readDomains(resp.Body)
+}
+
+// readDomains reads one domain per line from r, skipping blank lines.
+func readDomains(r io.Reader) ([]string, error) {
+	var domains []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			domains = append(domains, line)
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// readInputRows reads domains and their metadata from a CSV file with a
+// header row, or from NDJSON when the file ends in .ndjson, .jsonl or .json.
+// The domain comes from the "domain" column (the first column if there is
+// none); every other column becomes metadata. With strict, a malformed NDJSON
+// line is an error instead of a warning.
+func readInputRows(path string, strict bool) (domains []string, metas []map[string]string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isJSONInput(path) {
+		return readNDJSONRows(data, strict)
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, nil, err
+	}
+	col := 0
+	for i, name := range rows[0] {
+		if strings.EqualFold(strings.TrimSpace(name), "domain") {
+			col = i
+		}
+	}
+	for _, row := range rows[1:] {
+		if col >= len(row) || strings.TrimSpace(row[col]) == "" {
+			continue
+		}
+		meta := make(map[string]string)
+		for i, name := range rows[0] {
+			if i != col && i < len(row) {
+				meta[name] = row[i]
+			}
+		}
+		domains = append(domains, strings.TrimSpace(row[col]))
+		metas = append(metas, meta)
+	}
+	return domains, metas, nil
+}
+
+// isJSONInput reports whether path names an NDJSON (or JSON) input file.
+func isJSONInput(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl", ".json":
+		return true
+	}
+	return false
+}
+
+// readNDJSONRows reads one JSON object per line, taking the domain from its
+// "domain" field and every other field as metadata. Lines that are not JSON
+// objects or lack a domain are reported with their line number and skipped,
+// or with strict, returned as an error. A JSON array of objects is also
+// accepted.
+func readNDJSONRows(data []byte, strict bool) (domains []string, metas []map[string]string, err error) {
+	var lines [][]byte
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			lines = append(lines, item)
+		}
+	} else {
+		lines = bytes.Split(data, []byte("\n"))
+	}
+	for i, line := range lines {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		var row map[string]interface{}
+		var problem string
+		var typeErr *json.UnmarshalTypeError
+		if err := json.Unmarshal(line, &row); err != nil && !errors.As(err, &typeErr) {
+			problem = fmt.Sprintf("invalid JSON: %v", err)
+		} else if row == nil {
+			problem = "not a JSON object"
+		} else if domain, _ := row["domain"].(string); strings.TrimSpace(domain) == "" {
+			problem = `missing "domain" field`
+		}
+		if problem != "" {
+			if strict {
+				return nil, nil, fmt.Errorf("line %d: %s", i+1, problem)
+			}
+			logf(warnColor, "Skipping input line %d: %s", i+1, problem)
+			continue
+		}
+		meta := make(map[string]string)
+		for k, v := range row {
+			if k != "domain" {
+				meta[k] = fmt.Sprint(v)
+			}
+		}
+		domains = append(domains, strings.TrimSpace(row["domain"].(string)))
+		metas = append(metas, meta)
+	}
+	return domains, metas, nil
+}
+
+// keepOutput is set once the output file holds everything worth keeping: when
+// main returns, or, for streamed CSV, once the resumed results are written.
+// Until then an interrupted --skip-existing run leaves --output untouched.
+var keepOutput atomic.Bool
+
+// createOutput creates the --output file at path. When resuming a scan, it
+// creates a temporary file next to path instead, so the results being
+// resumed survive until commitOutput replaces path with the new output.
+func createOutput(path string, resume bool) (*os.File, error) {
+	if !resume {
+		return os.Create(path)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	f.Chmod(mode)
+	return f, nil
+}
+
+// commitOutput renames the temporary output file tmp over path if the output
+// is to be kept, and removes it otherwise.
+func commitOutput(tmp, path string) {
+	if !keepOutput.Load() {
+		os.Remove(tmp)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		logf(errorColor, "Error writing output file %s: %v", path, err)
+	}
+}
+
+// loadExistingResults reads results previously written to path in the given
+// format so an interrupted scan can be resumed. A missing file yields no
+// results. Compressed files are decompressed first.
+func loadExistingResults(path, format string, compressed bool) ([]DomainTXT, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if compressed && len(data) > 0 {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(gz); err != nil {
+			return nil, err
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var results []DomainTXT
+	switch strings.ToLower(format) {
+	case "json":
+		// Accept --envelope output as well as the bare array.
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			var env struct {
+				Results []DomainTXT `json:"results"`
+			}
+			if err := json.Unmarshal(data, &env); err != nil {
+				return nil, err
+			}
+			return env.Results, nil
+		}
+		if err := json.Unmarshal(data, &results); err != nil {
+			return nil, err
+		}
+	case "csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.Comma = csvDelimiter
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(rows[0], "Domain") {
+			return nil, fmt.Errorf("missing Domain column")
+		}
+		for _, row := range rows[1:] {
+			r, err := parseRow(rows[0], row)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, r)
+		}
+	case "ndjson":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		for dec.More() {
+			var r DomainTXT
+			if err := dec.Decode(&r); err != nil {
+				return nil, err
+			}
+			results = append(results, r)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format %q (use json, ndjson or csv)", format)
+	}
+	return results, nil
+}
+
+// unsafeFilenameChars matches characters not allowed in --output-dir file names.
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// safeFilename turns a domain into a safe file name.
+func safeFilename(domain string) string {
+	name := unsafeFilenameChars.ReplaceAllString(domain, "_")
+	if strings.Trim(name, ".") == "" {
+		name = "_"
+	}
+	return name
+}
+
+// formatForPath returns the output format implied by the extension of
+// path, ignoring a trailing .gz, or fallback for unknown extensions. Text
+// files (.txt) get the pretty table.
+func formatForPath(path, fallback string) string {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, ".gz")))
+	switch ext {
+	case ".json", ".ndjson", ".yaml", ".csv", ".zone", ".org", ".markdown":
+		return ext[1:]
+	case ".jsonl":
+		return "ndjson"
+	case ".yml":
+		return "yaml"
+	case ".md":
+		return "markdown"
+	case ".txt":
+		return "pretty"
+	default:
+		return fallback
+	}
+}
+
+// formatExtension returns the file extension used for an output format.
+func formatExtension(format string) string {
+	switch format {
+	case "json", "ndjson", "yaml", "csv", "zone", "org":
+		return format
+	case "markdown":
+		return "md"
+	default:
+		return "txt"
+	}
+}
+
+// sharedValues groups results by value and returns the values found on more
+// than one domain, in order of first appearance.
+func sharedValues(results []DomainTXT) []SharedValue {
+	var order []string
+	domains := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, r := range results {
+		if r.Value == "" || seen[r.Value+"\x00"+r.Domain] {
+			continue
+		}
+		seen[r.Value+"\x00"+r.Domain] = true
+		if _, ok := domains[r.Value]; !ok {
+			order = append(order, r.Value)
+		}
+		domains[r.Value] = append(domains[r.Value], r.Domain)
+	}
+	var shared []SharedValue
+	for _, value := range order {
+		if len(domains[value]) > 1 {
+			shared = append(shared, SharedValue{Value: value, Domains: domains[value]})
+		}
+	}
+	return shared
+}
+
+// sharedTokens returns the verification tokens (values of keys such as
+// google-site-verification) found on more than one domain, most shared first.
+func sharedTokens(results []DomainTXT) []SharedToken {
+	var tokens []DomainTXT
+	keys := make(map[string]string)
+	for _, r := range results {
+		if !strings.Contains(strings.ToLower(r.Key), "verif") {
+			continue
+		}
+		tokens = append(tokens, r)
+		if _, ok := keys[r.Value]; !ok {
+			keys[r.Value] = r.Key
+		}
+	}
+	var shared []SharedToken
+	for _, sv := range sharedValues(tokens) {
+		shared = append(shared, SharedToken{Key: keys[sv.Value], Token: sv.Value, Count: len(sv.Domains), Domains: sv.Domains})
+	}
+	sort.SliceStable(shared, func(i, j int) bool { return shared[i].Count > shared[j].Count })
+	return shared
+}
+
+// domainListed reports whether domain is in list or, with subdomains, is a
+// subdomain of a listed domain.
+func domainListed(list map[string]bool, domain string, subdomains bool) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if list[domain] {
+		return true
+	}
+	if subdomains {
+		for i := strings.IndexByte(domain, '.'); i >= 0; i = strings.IndexByte(domain, '.') {
+			domain = domain[i+1:]
+			if list[domain] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordSetHashes returns, for each of domains whose TXT lookup succeeded,
+// the sha256 of the records it publishes (as published, sorted and
+// deduplicated), so unchanged record sets hash the same across runs
+// whatever filters are in effect.
+func recordSetHashes(domains []string, published map[string][]string) []DomainHash {
+	hashes := make([]DomainHash, 0, len(domains))
+	for _, domain := range domains {
+		txts, ok := published[domain]
+		if !ok {
+			continue
+		}
+		txts = slices.Clone(txts)
+		sort.Strings(txts)
+		h := sha256.New()
+		for _, txt := range slices.Compact(txts) {
+			h.Write([]byte(txt))
+			h.Write([]byte{0})
+		}
+		hashes = append(hashes, DomainHash{Domain: domain, Hash: hex.EncodeToString(h.Sum(nil))})
+	}
+	return hashes
+}
+
+// buildMatrixRow reports which of keys appear among the results of domain. A
+// key matches a result's key or its simplified form, case-insensitively.
+func buildMatrixRow(domain string, keys []string, results []DomainTXT) MatrixRow {
+	row := MatrixRow{Domain: domain, Keys: make(map[string]bool)}
+	for _, k := range keys {
+		row.Keys[k] = false
+	}
+	for _, res := range results {
+		if !strings.EqualFold(res.Domain, domain) || res.Key == "" {
+			continue
+		}
+		for _, k := range keys {
+			if strings.EqualFold(res.Key, k) || strings.EqualFold(simplifyKey(res.Key), k) {
+				row.Keys[k] = true
+			}
+		}
+	}
+	return row
+}
+
+// printMatrix outputs the presence matrix. Pretty output marks cells with
+// ✓/✗, CSV with true/false.
+func printMatrix(format string, keys []string, matrix []MatrixRow) {
+	yes, no := "✓", "✗"
+	if format == "csv" {
+		yes, no = "true", "false"
+	}
+	header := append([]string{"Domain"}, keys...)
+	var rows [][]string
+	for _, m := range matrix {
+		row := []string{m.Domain}
+		for _, k := range keys {
+			if m.Keys[k] {
+				row = append(row, yes)
+			} else {
+				row = append(row, no)
+			}
+		}
+		rows = append(rows, row)
+	}
+	printRows(format, header, rows, matrix)
+}
+
+var (
+	cleanupMu      sync.Mutex
+	cleanups       []func()
+	runCleanups    sync.Once
+	watchInterrupt sync.Once
+)
+
+// atExit registers cleanup to run, in reverse registration order, when dnxty
+// exits: at the end of main, on a fatal error, or when interrupted with
+// SIGINT or SIGTERM. Files being written (profiles, gzip streams) are thus
+// finalized instead of truncated.
+func atExit(cleanup func()) {
+	cleanupMu.Lock()
+	cleanups = append(cleanups, cleanup)
+	cleanupMu.Unlock()
+	watchInterrupt.Do(func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			exit(130)
+		}()
+	})
+}
+
+// exit runs the cleanups registered with atExit, once, flushes queued
+// messages and exits with code. Every exit from dnxty goes through it.
+func exit(code int) {
+	runCleanups.Do(func() {
+		cleanupMu.Lock()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+		cleanupMu.Unlock()
+	})
+	flushPrinter()
+	os.Exit(code)
+}
+
+// printRows outputs a generic result set in the chosen format. Pretty and CSV
+// render header and rows, while JSON, NDJSON and YAML marshal data as-is.
+func printRows(format string, header []string, rows [][]string, data interface{}) {
+	switch strings.ToLower(format) {
+	case "pretty":
+		printTable(header, rows)
+	case "json":
+		b, err := json.MarshalIndent(wrapEnvelope(data), "", "  ")
+		if err != nil {
+			logf(errorColor, "Error marshalling JSON: %v", err)
+			return
+		}
+		printHighlighted(string(b), "json")
+	case "ndjson":
+		printNDJSON(data)
+	case "yaml":
+		b, err := yaml.Marshal(wrapEnvelope(data))
+		if err != nil {
+			logf(errorColor, "Error marshalling YAML: %v", err)
+			return
+		}
+		printHighlighted(string(b), "yaml")
+	case "csv":
+		printCSVRows(header, rows)
+	case "org":
+		printOrgTable(header, rows)
+	case "markdown":
+		printMarkdownTable(header, rows)
+	default:
+		logf(warnColor, "Unknown output format '%s'. Defaulting to pretty.", format)
+		printTable(header, rows)
+	}
+}
+
+// printNDJSON outputs every element of the slice items as a JSON object on
+// its own line, without highlighting, for line-oriented consumers.
+func printNDJSON(items interface{}) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		v = reflect.ValueOf([]interface{}{items})
+	}
+	enc := json.NewEncoder(output)
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			logf(errorColor, "Error marshalling JSON: %v", err)
+			return
+		}
+	}
+}
+
+// printTable outputs rows as a formatted table with a highlighted header.
+func printTable(header []string, rows [][]string) {
+	table := newTable()
+	table.SetHeader(header)
+	headerColors := make([]tablewriter.Colors, len(header))
+	for i := range headerColors {
+		headerColors[i] = tablewriter.Colors{tablewriter.FgHiBlueColor, tablewriter.Bold}
+	}
+	if !color.NoColor {
+		table.SetHeaderColor(headerColors...)
+	}
+	for _, row := range rows {
+		table.Append(tableRow(row))
+	}
+	table.Render()
+}
+
+// printCSVRows streams header and rows to output as CSV.
+func printCSVRows(header []string, rows [][]string) {
+	writer := newCSVWriter()
+	if err := writer.Write(header); err != nil {
+		logf(errorColor, "Error writing CSV header: %v", err)
+		return
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			logf(errorColor, "Error writing CSV: %v", err)
+			return
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logf(errorColor, "Error writing CSV: %v", err)
+	}
+}
+
+// newCSVWriter returns a CSV writer on output using --csv-delimiter,
+// --csv-crlf and --line-buffered.
+func newCSVWriter() csvWriter {
+	writer := csv.NewWriter(output)
+	writer.Comma = csvDelimiter
+	writer.UseCRLF = csvCRLF
+	return csvWriter{writer}
+}
+
+// csvWriter is a csv.Writer that, with --line-buffered, flushes every row
+// as it is written instead of in buffer-sized chunks.
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w csvWriter) Write(row []string) error {
+	if err := w.Writer.Write(row); err != nil {
+		return err
+	}
+	if lineBuffered {
+		w.Flush()
+		return w.Error()
+	}
+	return nil
+}
+
+// flushWriter flushes a buffering writer, such as the --gzip stream, after
+// every write for --line-buffered.
+type flushWriter struct {
+	w     io.Writer
+	flush func() error
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.flush()
+	}
+	return n, err
+}
+
+// printHighlighted prints s with syntax highlighting for lexer unless color
+// is disabled.
+func printHighlighted(s, lexer string) {
+	if !color.NoColor {
+		if err := quick.Highlight(output, s, lexer, "terminal", "monokai"); err != nil {
+			fmt.Fprintln(output, s)
+		}
+	} else {
+		fmt.Fprintln(output, s)
+	}
+}
+
+func lookupTXTRecords(domain string) ([]string, error) {
+	// net.Resolver cannot send EDNS options, so --ecs takes the raw path, as
+	// do wildcard names such as *.example.com, which it rejects.
+	if ecsSubnet != nil || strings.HasPrefix(domain, "*.") {
+		txts, _, _, err := lookupTXTChunks(domain)
+		return txts, err
+	}
+	txts, err := resolveWith(resolvers, domain, "TXT", func(server string) ([]string, error) {
+		ctx, cancel := queryContext("TXT")
+		defer cancel()
+		return createResolver(server).LookupTXT(ctx, domain)
+	}, txtSet)
+	if err != nil {
+		return nil, err
+	}
+
+	if verbose {
+		log.Printf("Resolved TXT records: %v", maskRecords(txts))
+	}
+
+	return txts, nil
+}
+
+// txtChunkSize is the maximum length of a single TXT character-string.
+const txtChunkSize = 255
+
+// lookupTXTChunks queries domain's TXT records directly and returns each
+// record reassembled, along with the character-strings it arrived in and
+// the CNAME chain that led to the records (nil when there was none). With
+// --verbose, records that look split at 255-byte boundaries are reported.
+func lookupTXTChunks(domain string) (txts []string, segments [][]string, chain []string, err error) {
+	r, err := queryRaw(domain, dns.TypeTXT)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	chain = cnameChain(domain, r.Answer)
+	for _, rr := range r.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		// The dns package keeps character-strings in escaped
+		// presentation form (e.g. \" and \009); turn them back into bytes.
+		segs := make([]string, len(txt.Txt))
+		for i, seg := range txt.Txt {
+			segs[i] = unescapeCharString(seg)
+		}
+		joined := strings.Join(segs, "")
+		if verbose && len(segs) > 1 {
+			split := true
+			for _, seg := range segs[:len(segs)-1] {
+				split = split && len(seg) == txtChunkSize
+			}
+			if split {
+				log.Printf("TXT record for %s was split at %d-byte boundaries into %d segments and reassembled", domain, txtChunkSize, len(txt.Txt))
+			}
+		}
+		txts = append(txts, joined)
+		segments = append(segments, segs)
+	}
+	return txts, segments, chain, nil
+}
+
+// cnameChain follows the CNAME records in answer from name and returns the
+// names visited, from name to the final target, or nil if name is not an
+// alias.
+func cnameChain(name string, answer []dns.RR) []string {
+	targets := make(map[string]string)
+	for _, rr := range answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			targets[strings.ToLower(cname.Hdr.Name)] = cname.Target
+		}
+	}
+	chain := []string{name}
+	current := dns.Fqdn(strings.ToLower(name))
+	for len(chain) <= len(targets) {
+		target, ok := targets[current]
+		if !ok {
+			break
+		}
+		chain = append(chain, strings.TrimSuffix(target, "."))
+		current = strings.ToLower(target)
+	}
+	if len(chain) == 1 {
+		return nil
+	}
+	return chain
+}
+
+// wildcardProbe holds the TXT records a random nonexistent name in a zone
+// resolved to, probed once per zone.
+type wildcardProbe struct {
+	once sync.Once
+	txts map[string]bool
+}
+
+var (
+	wildcardMu     sync.Mutex
+	wildcardProbes = make(map[string]*wildcardProbe)
+)
+
+// wildcardTXT returns the TXT records a wildcard in domain's parent zone
+// answers with, found by querying a random label next to domain. It returns
+// nil when the parent has no wildcard TXT records.
+func wildcardTXT(domain string) map[string]bool {
+	_, parent, ok := strings.Cut(domain, ".")
+	if !ok || !strings.Contains(parent, ".") {
+		return nil
+	}
+	wildcardMu.Lock()
+	probe, ok := wildcardProbes[parent]
+	if !ok {
+		probe = &wildcardProbe{}
+		wildcardProbes[parent] = probe
+	}
+	wildcardMu.Unlock()
+	probe.once.Do(func() {
+		name := fmt.Sprintf("dnxty-%016x.%s", rand.Uint64(), parent)
+		txts, err := lookupTXTRecords(name)
+		if err != nil || len(txts) == 0 {
+			return
+		}
+		if verbose {
+			log.Printf("Wildcard TXT records detected under %s: %v", parent, maskRecords(txts))
+		}
+		probe.txts = make(map[string]bool)
+		for _, txt := range txts {
+			probe.txts[txt] = true
+		}
+	})
+	return probe.txts
+}
+
+// zoneNS holds the NS set of a zone, looked up once per zone.
+type zoneNS struct {
+	once sync.Once
+	ns   []string
+	err  error
+}
+
+var (
+	zoneNSMu sync.Mutex
+	zoneNSes = make(map[string]*zoneNS)
+)
+
+// zoneAuthority returns the zone name belongs to and the sorted names of
+// its authoritative nameservers. A name without its own NS set is placed in
+// a zone by the SOA record resolvers return with a no-data answer, or by
+// walking up its labels when it does not exist.
+func zoneAuthority(name string) (zone string, ns []string, err error) {
+	name = dns.Fqdn(strings.ToLower(name))
+	for zone == "" {
+		r, err := queryRaw(name, dns.TypeNS)
+		switch {
+		case err == nil:
+			if owner, ns := nsRecords(r); len(ns) > 0 {
+				return strings.TrimSuffix(owner, "."), ns, nil
+			}
+			for _, rr := range r.Ns {
+				if soa, ok := rr.(*dns.SOA); ok {
+					zone = strings.ToLower(soa.Hdr.Name)
+				}
+			}
+		case !isNotFound(err):
+			return "", nil, err
+		}
+		if zone == "" {
+			_, parent, _ := strings.Cut(name, ".")
+			if parent == "" {
+				return "", nil, fmt.Errorf("no enclosing zone found")
+			}
+			name = parent
+		}
+	}
+	zoneNSMu.Lock()
+	z, ok := zoneNSes[zone]
+	if !ok {
+		z = &zoneNS{}
+		zoneNSes[zone] = z
+	}
+	zoneNSMu.Unlock()
+	z.once.Do(func() {
+		r, err := queryRaw(zone, dns.TypeNS)
+		if err != nil {
+			z.err = err
+			return
+		}
+		if _, z.ns = nsRecords(r); len(z.ns) == 0 {
+			z.err = fmt.Errorf("no NS records for %s", zone)
+		}
+	})
+	return strings.TrimSuffix(zone, "."), z.ns, z.err
+}
+
+// nsRecords returns the owner and sorted nameserver names of the NS records
+// in the answer of r.
+func nsRecords(r *dns.Msg) (owner string, ns []string) {
+	for _, rr := range r.Answer {
+		if rec, ok := rr.(*dns.NS); ok {
+			owner = strings.ToLower(rec.Hdr.Name)
+			ns = append(ns, strings.TrimSuffix(strings.ToLower(rec.Ns), "."))
+		}
+	}
+	sort.Strings(ns)
+	return owner, ns
+}
+
+// parkingSignature identifies a domain parking provider by the name servers
+// or mail exchangers it assigns to parked domains. Patterns match a host
+// name or any name under it.
+type parkingSignature struct {
+	Provider string
+	NS       []string
+	MX       []string
+}
+
+// parkingSignatures is the built-in table --detect-parked checks domains
+// against. Add providers here as they are confirmed.
+var parkingSignatures = []parkingSignature{
+	{Provider: "Sedo", NS: []string{"sedoparking.com"}},
+	{Provider: "ParkingCrew", NS: []string{"parkingcrew.net"}},
+	{Provider: "Bodis", NS: []string{"bodis.com"}},
+	{Provider: "Above.com", NS: []string{"above.com"}, MX: []string{"park-mx.above.com"}},
+	{Provider: "Dan.com", NS: []string{"dan.com"}},
+	{Provider: "ParkLogic", NS: []string{"parklogic.com"}},
+	{Provider: "Uniregistry Market", NS: []string{"uniregistrymarket.link"}},
+}
+
+// parkingProvider returns the parking provider whose signature matches the
+// NS or MX records of domain, or "" if the domain does not look parked.
+func parkingProvider(domain string) string {
+	var ns, mx []string
+	if r, err := queryRaw(domain, dns.TypeNS); err == nil {
+		for _, rr := range r.Answer {
+			if rec, ok := rr.(*dns.NS); ok {
+				ns = append(ns, strings.ToLower(strings.TrimSuffix(rec.Ns, ".")))
+			}
+		}
+	}
+	if r, err := queryRaw(domain, dns.TypeMX); err == nil {
+		for _, rr := range r.Answer {
+			if rec, ok := rr.(*dns.MX); ok {
+				mx = append(mx, strings.ToLower(strings.TrimSuffix(rec.Mx, ".")))
+			}
+		}
+	}
+	matches := func(hosts, patterns []string) bool {
+		for _, host := range hosts {
+			for _, pattern := range patterns {
+				if host == pattern || strings.HasSuffix(host, "."+pattern) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for _, sig := range parkingSignatures {
+		if matches(ns, sig.NS) || matches(mx, sig.MX) {
+			return sig.Provider
+		}
+	}
+	return ""
+}
+
+// recordLookups maps each supported non-TXT record type to its lookup
+// function. TXT records go through the regular extraction pipeline instead.
+var recordLookups = map[string]func(domain string) ([]DomainTXT, error){
+	"CAA":   lookupCAA,
+	"SRV":   lookupSRV,
+	"NAPTR": lookupNAPTR,
+}
+
+// parseRecordTypes parses the comma-separated --type value into a list of
+// upper-cased, de-duplicated record types and rejects unsupported ones.
+func parseRecordTypes(s string) ([]string, error) {
+	var types []string
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToUpper(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if _, ok := recordLookups[t]; !ok && t != "TXT" {
+			return nil, fmt.Errorf("unsupported record type %q", t)
+		}
+		seen[t] = true
+		types = append(types, t)
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no record type given")
+	}
+	return types, nil
+}
+
+// lookupCAA queries the CAA records of domain. The tag and value map onto
+// Key and Value so CAA rows fit the regular output formats.
+func lookupCAA(domain string) ([]DomainTXT, error) {
+	r, err := queryRaw(domain, dns.TypeCAA)
+	if err != nil {
+		return nil, err
+	}
+	var records []DomainTXT
+	for _, rr := range r.Answer {
+		caa, ok := rr.(*dns.CAA)
+		if !ok {
+			continue
+		}
+		records = append(records, DomainTXT{
+			Domain: domain,
+			TXT:    fmt.Sprintf("%d %s %q", caa.Flag, caa.Tag, caa.Value),
+			Key:    caa.Tag,
+			Value:  caa.Value,
+			Type:   "CAA",
+			CAA:    &CAARecord{Flags: caa.Flag, Tag: caa.Tag, Value: caa.Value},
+		})
+	}
+	return records, nil
+}
+
+// spfLookupLimit is the number of DNS-querying SPF terms (and thus the include
+// depth) allowed by RFC 7208 before evaluation fails with a permerror.
+const spfLookupLimit = 10
+
+// maxSPFRecursion bounds how deep buildSPFTree follows includes so broken or
+// malicious records cannot recurse forever.
+const maxSPFRecursion = 20
+
+// lookupSPF returns the SPF record published by domain.
+func lookupSPF(domain string) (string, error) {
+	txts, err := lookupTXTRecords(domain)
+	if err != nil {
+		return "", err
+	}
+	for _, txt := range txts {
+		if isSPF(txt) {
+			return txt, nil
+		}
+	}
+	return "", errNoSPF
+}
+
+// isSPF reports whether txt is an SPF record: "v=spf1" (in any case) on its
+// own or followed by a space, so records such as "v=spf10" do not count.
+func isSPF(txt string) bool {
+	if len(txt) < len("v=spf1") || !strings.EqualFold(txt[:len("v=spf1")], "v=spf1") {
+		return false
+	}
+	return len(txt) == len("v=spf1") || txt[len("v=spf1")] == ' '
+}
+
+// errNoSPF is returned by lookupSPF when a domain publishes no SPF record.
+var errNoSPF = errors.New("no SPF record found")
+
+// errEmptyAnswer marks a lookup that succeeded without records, so that
+// --retry-on-empty can retry it.
+var errEmptyAnswer = errors.New("empty answer")
+
+// summarizeSPF looks up the SPF record of domain and reduces it to its
+// posture: whether it exists, its all qualifier and its number of includes.
+func summarizeSPF(domain string) SPFSummary {
+	summary := SPFSummary{Domain: domain}
+	record, err := lookupSPF(domain)
+	if err != nil {
+		if err != errNoSPF {
+			summary.Error = err.Error()
+		}
+		return summary
+	}
+	summary.HasSPF = true
+	for _, term := range strings.Fields(record)[1:] {
+		lower := strings.ToLower(term)
+		switch {
+		case lower == "all":
+			summary.All = "+all"
+		case len(lower) == 4 && strings.HasSuffix(lower, "all") && strings.ContainsAny(lower[:1], "+-~?"):
+			summary.All = lower
+		case strings.Contains(lower, "include:"):
+			summary.Includes++
+		}
+	}
+	return summary
+}
+
+// dmarcReport matches the domains of DMARC rua/ruf report addresses.
+var dmarcReport = regexp.MustCompile(`(?i)mailto:[^@,;\s]+@([^!,;\s]+)`)
+
+// recordReferences returns the domains an SPF or DMARC record refers to:
+// SPF include: and redirect= targets and DMARC report address domains.
+func recordReferences(txt string) []string {
+	var refs []string
+	lower := strings.ToLower(txt)
+	switch {
+	case isSPF(txt):
+		for _, term := range strings.Fields(txt)[1:] {
+			if target := spfTarget(term); target != "" {
+				refs = append(refs, strings.ToLower(target))
+			}
+		}
+	case strings.HasPrefix(lower, "v=dmarc1"):
+		for _, m := range dmarcReport.FindAllStringSubmatch(txt, -1) {
+			refs = append(refs, strings.ToLower(m[1]))
+		}
+	}
+	return refs
+}
+
+// expandReferences resolves the A and MX records of every domain the
+// results refer to, following references up to depth levels, and attaches
+// them to the results. Each domain is resolved once however often it is
+// referenced.
+func expandReferences(results []DomainTXT, depth, concurrency int) {
+	resolved := make(map[string]*Reference)
+	// refsOf lists, for each record text, the references it points to.
+	refsOf := make(map[string][]*Reference)
+	var level []string
+	for _, r := range results {
+		if _, ok := refsOf[r.TXT]; ok || r.Type != "" {
+			continue
+		}
+		refsOf[r.TXT] = nil
+		for _, domain := range recordReferences(r.TXT) {
+			if resolved[domain] == nil {
+				resolved[domain] = &Reference{Domain: domain}
+				level = append(level, domain)
+			}
+			refsOf[r.TXT] = append(refsOf[r.TXT], resolved[domain])
+		}
+	}
+	for d := 1; d <= depth && len(level) > 0; d++ {
+		refs := make([]*Reference, len(level))
+		for i, domain := range level {
+			refs[i] = resolved[domain]
+		}
+		// Resolve this level; on all but the last, also collect the domains
+		// each referenced domain's own records refer to.
+		nested := make([][]string, len(level))
+		forEachDomain(level, concurrency, func(i int, domain string) {
+			resolveReference(refs[i])
+			if d == depth {
+				return
+			}
+			if txts, err := lookupTXTRecords(domain); err == nil {
+				for _, txt := range txts {
+					nested[i] = append(nested[i], recordReferences(txt)...)
+				}
+			}
+		})
+		// Domains already resolved elsewhere are not repeated, which also
+		// keeps reference loops out of the tree.
+		var next []string
+		for i, domains := range nested {
+			for _, domain := range domains {
+				if resolved[domain] != nil {
+					continue
+				}
+				resolved[domain] = &Reference{Domain: domain}
+				next = append(next, domain)
+				refs[i].References = append(refs[i].References, resolved[domain])
+			}
+		}
+		level = next
+	}
+	for i := range results {
+		results[i].References = refsOf[results[i].TXT]
+	}
+}
+
+// resolveReference fills in the A and MX records of ref.
+func resolveReference(ref *Reference) {
+	var errs []string
+	if r, err := queryRaw(ref.Domain, dns.TypeA); err != nil {
+		errs = append(errs, "A: "+err.Error())
+	} else {
+		for _, rr := range r.Answer {
+			if a, ok := rr.(*dns.A); ok {
+				ref.A = append(ref.A, a.A.String())
+			}
+		}
+	}
+	if r, err := queryRaw(ref.Domain, dns.TypeMX); err != nil {
+		errs = append(errs, "MX: "+err.Error())
+	} else {
+		for _, rr := range r.Answer {
+			if mx, ok := rr.(*dns.MX); ok {
+				ref.MX = append(ref.MX, strings.TrimSuffix(mx.Mx, "."))
+			}
+		}
+	}
+	ref.Error = strings.Join(errs, "; ")
+}
+
+// spfModifier matches SPF modifiers such as redirect= and exp=, which take
+// no qualifier.
+var spfModifier = regexp.MustCompile(`^[a-z][a-z0-9_.\-]*=`)
+
+// canonicalSPF rewrites an SPF record in a canonical form: lowercase, single
+// spaces and an explicit qualifier on every mechanism. With sorted, the
+// mechanisms are also sorted, with all last and modifiers after it;
+// otherwise the order is kept, since it affects evaluation.
+func canonicalSPF(record string, sorted bool) string {
+	terms := []string{"v=spf1"}
+	var mechanisms, all, modifiers []string
+	for _, term := range strings.Fields(record)[1:] {
+		term = strings.ToLower(term)
+		switch {
+		case spfModifier.MatchString(term):
+			modifiers = append(modifiers, term)
+			terms = append(terms, term)
+			continue
+		case !strings.ContainsAny(term[:1], "+-~?"):
+			term = "+" + term
+		}
+		if term[1:] == "all" {
+			all = append(all, term)
+		} else {
+			mechanisms = append(mechanisms, term)
+		}
+		terms = append(terms, term)
+	}
+	if sorted {
+		sort.Strings(mechanisms)
+		sort.Strings(modifiers)
+		terms = append(append(append(terms[:1], mechanisms...), all...), modifiers...)
+	}
+	return strings.Join(terms, " ")
+}
+
+// spfTarget returns the domain referenced by an include: mechanism or a
+// redirect= modifier, or "" for any other term.
+func spfTarget(term string) string {
+	lower := strings.ToLower(term)
+	for _, prefix := range []string{"include:", "+include:", "~include:", "?include:", "-include:", "redirect="} {
+		if strings.HasPrefix(lower, prefix) {
+			return term[len(prefix):]
+		}
+	}
+	return ""
+}
+
+// buildSPFTree resolves the SPF record of domain and, recursively, every
+// record it includes.
+func buildSPFTree(domain string) SPFTree {
+	tree := SPFTree{Domain: domain}
+	tree.SPF = resolveSPFNode(domain, 0, map[string]bool{}, &tree.IncludeDepth)
+	tree.DepthExceeded = tree.IncludeDepth > spfLookupLimit
+	return tree
+}
+
+// resolveSPFNode builds the SPF node for domain at the given depth, tracking
+// the deepest include reached in maxDepth. Domains already on the current
+// path are reported as loops instead of being followed again.
+func resolveSPFNode(domain string, depth int, path map[string]bool, maxDepth *int) *SPFNode {
+	node := &SPFNode{Domain: domain}
+	if depth > *maxDepth {
+		*maxDepth = depth
+	}
+	key := strings.ToLower(domain)
+	if path[key] {
+		node.Error = "include loop detected"
+		return node
+	}
+	if depth > maxSPFRecursion {
+		node.Error = "maximum include depth reached"
+		return node
+	}
+	record, err := lookupSPF(domain)
+	if err != nil {
+		node.Error = err.Error()
+		return node
+	}
+	fields := strings.Fields(record)
+	node.Mechanisms = fields[1:]
+	path[key] = true
+	for _, term := range node.Mechanisms {
+		if target := spfTarget(term); target != "" {
+			node.Includes = append(node.Includes, resolveSPFNode(target, depth+1, path, maxDepth))
+		}
+	}
+	delete(path, key)
+	return node
+}
+
+// spfLookupTerms are the SPF mechanisms and modifiers that cost a DNS
+// lookup and count towards spfLookupLimit (RFC 7208, section 4.6.4).
+var spfLookupTerms = map[string]bool{"include": true, "a": true, "mx": true, "ptr": true, "exists": true, "redirect": true}
+
+// countSPFLookups resolves the SPF include tree of domain and counts the
+// lookup-incurring terms across every record in it, by term name.
+func countSPFLookups(domain string) SPFLookupCount {
+	count := SPFLookupCount{Domain: domain}
+	tree := buildSPFTree(domain)
+	if tree.SPF.Error != "" {
+		count.Error = tree.SPF.Error
+		return count
+	}
+	count.Breakdown = make(map[string]int)
+	var walk func(node *SPFNode)
+	walk = func(node *SPFNode) {
+		for _, term := range node.Mechanisms {
+			name := strings.ToLower(strings.TrimLeft(term, "+-~?"))
+			if i := strings.IndexAny(name, ":/="); i >= 0 {
+				name = name[:i]
+			}
+			if spfLookupTerms[name] {
+				count.Breakdown[name]++
+				count.Lookups++
+			}
+		}
+		for _, child := range node.Includes {
+			walk(child)
+		}
+	}
+	walk(tree.SPF)
+	count.Exceeded = count.Lookups > spfLookupLimit
+	return count
+}
+
+// validateSPF looks up the SPF records of domain and lints them: more than
+// one record, malformed or unknown terms, deprecated ptr, a permissive or
+// missing all, terms that are never reached and the lookup limit.
+func validateSPF(domain string) SPFValidation {
+	v := SPFValidation{Domain: domain, Findings: []Finding{}}
+	add := func(severity, term, format string, args ...any) {
+		v.Findings = append(v.Findings, Finding{Severity: severity, Term: term, Message: fmt.Sprintf(format, args...)})
+	}
+	txts, err := lookupTXTRecords(domain)
+	if err != nil && !isNotFound(err) {
+		v.Error = err.Error()
+		return v
+	}
+	var records []string
+	for _, txt := range txts {
+		if isSPF(txt) {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		add("warning", "", "no SPF record; receivers cannot check senders for this domain")
+		return v
+	case 1:
+	default:
+		add("error", "", "%d SPF records published; receivers fail SPF with a permerror when there is more than one", len(records))
+	}
+	for _, record := range records {
+		lintSPFRecord(record, add)
+	}
+	if count := countSPFLookups(domain); count.Exceeded {
+		add("error", "", "evaluation needs %d DNS lookups, over the limit of %d", count.Lookups, spfLookupLimit)
+	}
+	return v
+}
+
+// lintSPFRecord checks the terms of a single SPF record, reporting each
+// finding through add.
+func lintSPFRecord(record string, add func(severity, term, format string, args ...any)) {
+	var all string
+	modifiers := make(map[string]bool)
+	for _, term := range strings.Fields(record)[1:] {
+		lower := strings.ToLower(term)
+		if all != "" && !spfModifier.MatchString(lower) {
+			add("warning", term, "never evaluated: it comes after %s", all)
+		}
+		if name, value, ok := strings.Cut(lower, "="); ok && spfModifier.MatchString(lower) {
+			if modifiers[name] && (name == "redirect" || name == "exp") {
+				add("error", term, "%s= appears more than once", name)
+			}
+			modifiers[name] = true
+			switch name {
+			case "redirect", "exp":
+				if !validDomainSpec(value) {
+					add("error", term, "%s= needs a domain", name)
+				}
+			}
+			continue
+		}
+		name := strings.TrimLeft(lower, "+-~?")
+		if len(lower)-len(name) > 1 {
+			add("error", term, "more than one qualifier")
+			continue
+		}
+		arg := ""
+		if i := strings.IndexAny(name, ":/"); i >= 0 {
+			name, arg = name[:i], name[i:]
+		}
+		switch name {
+		case "all":
+			if arg != "" {
+				add("error", term, "all takes no argument")
+			}
+			all = term
+			switch lower[0] {
+			case '-', '~':
+			case '?':
+				add("warning", term, "?all gives unmatched senders a neutral result, which receivers treat like no SPF")
+			default:
+				add("error", term, "+all lets any host send mail as this domain")
+			}
+		case "include", "exists":
+			if !strings.HasPrefix(arg, ":") || !validDomainSpec(arg[1:]) {
+				add("error", term, "%s needs a domain, as %s:example.com", name, name)
+			}
+		case "a", "mx", "ptr":
+			if name == "ptr" {
+				add("warning", term, "ptr is deprecated (RFC 7208, section 5.5); it is slow and unreliable")
+			}
+			spec, cidr, _ := strings.Cut(arg, "/")
+			if spec != "" && !validDomainSpec(strings.TrimPrefix(spec, ":")) {
+				add("error", term, "invalid domain")
+			}
+			if cidr != "" && (name == "ptr" || !validDualCIDR("/"+cidr)) {
+				add("error", term, "invalid prefix length")
+			}
+		case "ip4", "ip6":
+			addr, prefix, hasPrefix := strings.Cut(strings.TrimPrefix(arg, ":"), "/")
+			ip := net.ParseIP(addr)
+			isIP6 := strings.Contains(addr, ":")
+			bits := 32
+			if name == "ip6" {
+				bits = 128
+			}
+			switch {
+			case !strings.HasPrefix(arg, ":") || ip == nil || isIP6 != (name == "ip6"):
+				add("error", term, "invalid %s address", strings.ToUpper(name))
+			case hasPrefix && !validPrefix(prefix, bits):
+				add("error", term, "invalid prefix length")
+			}
+		default:
+			add("error", term, "unknown mechanism")
+		}
+	}
+	if all == "" && !modifiers["redirect"] {
+		add("warning", "", "no all mechanism or redirect=; unmatched senders get a neutral result")
+	}
+	if all != "" && modifiers["redirect"] {
+		add("info", "", "redirect= is ignored because the record ends in %s", all)
+	}
+}
+
+// appendFindingRows adds the table rows of a domain's findings to rows: one
+// per finding, or a single row for a failed lookup or a clean record.
+func appendFindingRows(rows [][]string, domain, lookupErr string, findings []Finding) [][]string {
+	switch {
+	case lookupErr != "":
+		return append(rows, []string{domain, "error", "", "lookup failed: " + lookupErr})
+	case len(findings) == 0:
+		return append(rows, []string{domain, "ok", "", "no problems found"})
+	}
+	for _, f := range findings {
+		rows = append(rows, []string{domain, f.Severity, f.Term, f.Message})
+	}
+	return rows
+}
+
+// dmarcPolicies are the valid values of the DMARC p and sp tags.
+var dmarcPolicies = map[string]bool{"none": true, "quarantine": true, "reject": true}
+
+// validateDMARC looks up the DMARC record of domain and lints its tags:
+// the policy, value ranges and syntax, and whether external report
+// addresses have authorized receiving reports for the domain.
+func validateDMARC(domain string) DMARCValidation {
+	v := DMARCValidation{Domain: domain, Findings: []Finding{}}
+	add := func(severity, term, format string, args ...any) {
+		v.Findings = append(v.Findings, Finding{Severity: severity, Term: term, Message: fmt.Sprintf(format, args...)})
+	}
+	txts, err := lookupTXTRecords("_dmarc." + domain)
+	if err != nil && !isNotFound(err) {
+		v.Error = err.Error()
+		return v
+	}
+	var records []string
+	for _, txt := range txts {
+		if strings.HasPrefix(strings.ToLower(txt), "v=dmarc1") {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		add("warning", "", "no DMARC record at _dmarc.%s; receivers apply no policy", domain)
+		return v
+	case 1:
+	default:
+		add("error", "", "%d DMARC records published; receivers ignore all of them when there is more than one", len(records))
+		return v
+	}
+
+	tags := make(map[string]string)
+	for i, part := range strings.Split(records[0], ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		switch {
+		case !ok:
+			add("error", part, "not a tag=value pair")
+			continue
+		case i == 0:
+			if value != "DMARC1" {
+				add("error", part, "the version must be exactly v=DMARC1")
+			}
+			continue
+		case name == "v":
+			add("error", part, "v= must be the first tag")
+			continue
+		}
+		if _, dup := tags[name]; dup {
+			add("error", part, "%s appears more than once", name)
+		}
+		tags[name] = value
+		lower := strings.ToLower(value)
+		switch name {
+		case "p", "sp":
+			if !dmarcPolicies[lower] {
+				add("error", part, "%s must be none, quarantine or reject", name)
+			} else if lower == "none" {
+				add("info", part, "monitoring only: failing mail is not quarantined or rejected")
+			}
+		case "pct":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 100 {
+				add("error", part, "pct must be a whole number from 0 to 100")
+			} else if n < 100 {
+				add("info", part, "the policy applies to only %d%% of failing mail", n)
+			}
+		case "adkim", "aspf":
+			if lower != "r" && lower != "s" {
+				add("error", part, "%s must be r (relaxed) or s (strict)", name)
+			}
+		case "ri":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				add("error", part, "ri must be a number of seconds")
+			}
+		case "fo":
+			for _, opt := range strings.Split(lower, ":") {
+				if opt != "0" && opt != "1" && opt != "d" && opt != "s" {
+					add("error", part, "fo options are 0, 1, d and s, separated by colons")
+					break
+				}
+			}
+		case "rf":
+			for _, format := range strings.Split(lower, ":") {
+				if format != "afrf" {
+					add("warning", part, "unknown report format %q (only afrf is defined)", format)
+				}
+			}
+		case "rua", "ruf":
+			lintDMARCReportURIs(domain, part, value, add)
+		default:
+			add("warning", part, "unknown tag %s", name)
+		}
+	}
+	if _, ok := tags["p"]; !ok {
+		add("error", "", "missing the required p tag")
+	}
+	if _, ok := tags["rua"]; !ok {
+		add("info", "", "no rua: the domain receives no aggregate reports")
+	}
+	return v
+}
+
+// lintDMARCReportURIs checks the comma-separated rua or ruf URIs in value.
+// Addresses outside domain must be authorized by a
+// domain._report._dmarc.<report domain> record (RFC 7489, section 7.1).
+// Without the public suffix list, domains count as related when one is a
+// subdomain of the other.
+func lintDMARCReportURIs(domain, term, value string, add func(severity, term, format string, args ...any)) {
+	for _, uri := range strings.Split(value, ",") {
+		uri = strings.TrimSpace(uri)
+		addr, ok := strings.CutPrefix(strings.ToLower(uri), "mailto:")
+		if i := strings.Index(addr, "!"); i >= 0 {
+			addr = addr[:i]
+		}
+		_, reportDomain, hasAt := strings.Cut(addr, "@")
+		if !ok || !hasAt {
+			add("error", term, "%q is not a mailto: address", uri)
+			continue
+		}
+		if _, err := sanitizeDomain(reportDomain); err != nil {
+			add("error", term, "%q has an invalid domain", uri)
+			continue
+		}
+		d := strings.ToLower(domain)
+		if reportDomain == d || strings.HasSuffix(reportDomain, "."+d) || strings.HasSuffix(d, "."+reportDomain) {
+			continue
+		}
+		authorized := false
+		txts, err := lookupTXTRecords(d + "._report._dmarc." + reportDomain)
+		for _, txt := range txts {
+			if strings.HasPrefix(strings.ToLower(txt), "v=dmarc1") {
+				authorized = true
+			}
+		}
+		switch {
+		case err != nil && !isNotFound(err):
+			add("warning", term, "could not check that %s accepts reports for %s: %v", reportDomain, domain, err)
+		case !authorized:
+			add("error", term, "%s has not authorized reports for %s (no %s._report._dmarc.%s record); receivers will not send them", reportDomain, domain, d, reportDomain)
+		}
+	}
+}
+
+// validDomainSpec reports whether s can be an SPF domain-spec. Specs with
+// macros are only checked for being non-empty.
+func validDomainSpec(s string) bool {
+	if strings.Contains(s, "%") {
+		return s != ""
+	}
+	_, err := sanitizeDomain(s)
+	return err == nil && !strings.ContainsAny(s, ":/@?#")
+}
+
+// validDualCIDR reports whether s is an SPF dual-cidr-length such as /24,
+// //64 or /24//64.
+func validDualCIDR(s string) bool {
+	ip4, ip6, dual := strings.Cut(s, "//")
+	if ip4 != "" && !validPrefix(strings.TrimPrefix(ip4, "/"), 32) {
+		return false
+	}
+	return !dual || validPrefix(ip6, 128)
+}
+
+// validPrefix reports whether s is a prefix length from 0 to bits.
+func validPrefix(s string, bits int) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 0 && n <= bits && !strings.HasPrefix(s, "+")
+}
+
+// formatBreakdown renders per-term lookup counts as "include:3 mx:1",
+// sorted by term.
+func formatBreakdown(breakdown map[string]int) string {
+	var parts []string
+	for name, n := range breakdown {
+		parts = append(parts, fmt.Sprintf("%s:%d", name, n))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+// printSPFTrees outputs SPF include trees. Pretty output renders an indented
+// tree; CSV flattens it into one row per node.
+func printSPFTrees(format string, trees []SPFTree) {
+	if strings.ToLower(format) == "pretty" {
+		for _, tree := range trees {
+			printSPFNode(tree.SPF, 0)
+			line := fmt.Sprintf("include depth: %d", tree.IncludeDepth)
+			if tree.DepthExceeded {
+				color.New(color.FgYellow).Fprintf(output, "%s (exceeds the SPF limit of %d)\n", line, spfLookupLimit)
+			} else {
+				fmt.Fprintln(output, line)
+			}
+			fmt.Fprintln(output)
+		}
+		return
+	}
+	var rows [][]string
+	for _, tree := range trees {
+		rows = appendSPFRows(rows, tree.Domain, "", tree.SPF, 0)
+	}
+	printRows(format, []string{"Domain", "Depth", "SPF Domain", "Parent", "Mechanisms", "Error"}, rows, trees)
+}
+
+// printSPFNode prints node and its includes, indenting each level.
+func printSPFNode(node *SPFNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+	if node.Error != "" {
+		color.New(color.FgRed).Fprintf(output, "%s%s: %s\n", indent, node.Domain, node.Error)
+	} else {
+		fmt.Fprintf(output, "%s%s: %s\n", indent, node.Domain, strings.Join(node.Mechanisms, " "))
+	}
+	for _, child := range node.Includes {
+		printSPFNode(child, depth+1)
+	}
+}
+
+// appendSPFRows flattens node and its includes into CSV rows.
+func appendSPFRows(rows [][]string, domain, parent string, node *SPFNode, depth int) [][]string {
+	rows = append(rows, []string{domain, fmt.Sprint(depth), node.Domain, parent, strings.Join(node.Mechanisms, " "), node.Error})
+	for _, child := range node.Includes {
+		rows = appendSPFRows(rows, domain, node.Domain, child, depth+1)
+	}
+	return rows
+}
+
+// benchmarkDomains are well-known domains that reliably publish TXT records,
+// used by --benchmark to exercise the resolver.
+var benchmarkDomains = []string{
+	"google.com",
+	"microsoft.com",
+	"apple.com",
+	"amazon.com",
+	"cloudflare.com",
+	"github.com",
+	"wikipedia.org",
+	"mozilla.org",
+}
+
+// runBenchmark looks up the TXT records of every benchmark domain through
+// each resolver in turn and reports, per resolver, the success rate and the
+// average latency of the successful lookups. With several resolvers, a
+// final "all" row covers them together.
+func runBenchmark() []BenchmarkResult {
+	servers := resolvers.servers
+	if len(servers) == 0 {
+		servers = []string{dnsServer}
+	}
+	var results []BenchmarkResult
+	all := BenchmarkResult{Resolver: "all"}
+	var allLatency time.Duration
+	for _, server := range servers {
+		res, latency := benchmarkResolver(server)
+		results = append(results, res)
+		all.Queries += res.Queries
+		all.Succeeded += res.Succeeded
+		allLatency += latency
+	}
+	if len(servers) > 1 {
+		all.setRates(allLatency)
+		results = append(results, all)
+	}
+	return results
+}
+
+// benchmarkResolver looks up the benchmark domains through server (the
+// system resolver if empty), returning the result and the summed latency of
+// the successful lookups.
+func benchmarkResolver(server string) (res BenchmarkResult, latency time.Duration) {
+	res = BenchmarkResult{Resolver: server, Queries: len(benchmarkDomains)}
+	if server == "" {
+		res.Resolver = "system"
+	}
+	resolver := createResolver(server)
+	for _, domain := range benchmarkDomains {
+		ctx, cancel := queryContext("TXT")
+		start := time.Now()
+		_, err := resolver.LookupTXT(ctx, domain)
+		elapsed := time.Since(start)
+		cancel()
+		if err != nil {
+			if verbose {
+				log.Printf("Benchmark lookup for %s via %s failed after %v: %v", domain, res.Resolver, elapsed, err)
+			}
+			continue
+		}
+		res.Succeeded++
+		latency += elapsed
+	}
+	res.setRates(latency)
+	return res, latency
+}
+
+// setRates fills in the success rate and the average latency, given the
+// summed latency of the successful queries.
+func (r *BenchmarkResult) setRates(latency time.Duration) {
+	if r.Queries > 0 {
+		r.SuccessRate = float64(r.Succeeded) / float64(r.Queries)
+	}
+	if r.Succeeded > 0 {
+		r.AvgLatencyMs = float64(latency.Microseconds()) / float64(r.Succeeded) / 1000
+	}
+}
+
+// lookupSRV queries the SRV records of name, which is expected to have the
+// _service._proto.domain form (see --prefix). The target and port map onto
+// Key and Value.
+func lookupSRV(name string) ([]DomainTXT, error) {
+	if labels := dns.SplitDomainName(name); verbose && (len(labels) < 3 ||
+		!strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_")) {
+		log.Printf("%s is not of the form _service._proto.domain; SRV lookups usually need --prefix (e.g. --prefix _sip._tcp)", name)
+	}
+	r, err := queryRaw(name, dns.TypeSRV)
+	if err != nil {
+		return nil, err
+	}
+	var records []DomainTXT
+	for _, rr := range r.Answer {
+		srv, ok := rr.(*dns.SRV)
+		if !ok {
+			continue
+		}
+		target := strings.TrimSuffix(srv.Target, ".")
+		records = append(records, DomainTXT{
+			Domain: name,
+			TXT:    fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, srv.Target),
+			Key:    target,
+			Value:  fmt.Sprint(srv.Port),
+			Type:   "SRV",
+			SRV:    &SRVRecord{Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: target},
+		})
+	}
+	return records, nil
+}
+
+// retryBackoff is the delay before the first retry; it doubles on each
+// subsequent retry of the same lookup.
+const retryBackoff = 250 * time.Millisecond
+
+// retryBudget is a pool of retries shared by all lookups so widespread
+// failures (e.g. a dead resolver) don't multiply into a storm of queries.
+type retryBudget struct {
+	mu        sync.Mutex
+	remaining int // negative means unlimited
+	exhausted bool
+}
+
+// budget is the retry budget of the current run, sized by --retry-budget.
+var budget = &retryBudget{remaining: -1}
+
+// take consumes one retry from the budget, reporting false once it is
+// exhausted.
+func (b *retryBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.remaining < 0 {
+		return true
+	}
+	if b.remaining == 0 {
+		if !b.exhausted && verbose {
+			log.Printf("Retry budget exhausted; further failures will not be retried")
+		}
+		b.exhausted = true
+		return false
+	}
+	b.remaining--
+	return true
+}
+
+// resolverPool spreads queries across the --resolvers servers in turn. When
+// slots is set, each server has at most cap(slots[i]) queries in flight.
+type resolverPool struct {
+	servers []string
+	slots   []chan struct{}
+	mu      sync.Mutex
+	next    int
+	// strategy is how a query uses the servers: round-robin, first,
+	// fastest or all; see resolveWith.
+	strategy string
+	// disagreements holds, by disagreementKey, what each server answered
+	// for lookups the servers disagreed on under the all strategy.
+	disagreements map[string][]ResolverAnswer
+	// rotateAfter drops a server after that many consecutive failed
+	// queries (0 never drops one); a dropped server is used again after
+	// cooldown, or never when cooldown is 0.
+	rotateAfter int
+	cooldown    time.Duration
+	streaks     []int
+	droppedAt   []time.Time
+	// dropped lists every server dropped during the run, for --stats.
+	dropped []string
+}
+
+// ResolverAnswer is what one resolver returned for a lookup the resolvers
+// disagreed on.
+type ResolverAnswer struct {
+	Resolver string   `json:"resolver" yaml:"resolver"`
+	Records  []string `json:"records,omitempty" yaml:"records,omitempty"`
+	NotFound bool     `json:"not_found,omitempty" yaml:"not_found,omitempty"`
+}
+
+// disagreementKey identifies a lookup in resolverPool.disagreements.
+func disagreementKey(qtype, name string) string {
+	return qtype + " " + strings.ToLower(name)
+}
+
+// disagreement returns the per-server answers of a lookup the servers
+// disagreed on, or nil.
+func (p *resolverPool) disagreement(qtype, name string) []ResolverAnswer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disagreements[disagreementKey(qtype, name)]
+}
+
+// compares reports whether every query goes to several servers whose answers
+// are compared (--resolver-strategy all), setting Disagreement.
+func (p *resolverPool) compares() bool {
+	return p.strategy == "all" && len(p.servers) > 1
+}
+
+// resolvers is the resolver pool of the current run, set from --resolvers.
+var resolvers = &resolverPool{}
+
+// acquire picks the server for one query and returns a function releasing
+// its slot. Without --resolvers it returns the --dns server (possibly empty,
+// meaning the system resolver). A server with a free slot is preferred over
+// waiting on the next one in turn.
+func (p *resolverPool) acquire() (server string, release func()) {
+	if len(p.servers) == 0 {
+		return dnsServer, func() {}
+	}
+	active := p.active()
+	p.mu.Lock()
+	first := p.next % len(active)
+	p.next = first + 1
+	p.mu.Unlock()
+	start := active[first]
+	if p.slots == nil {
+		return p.servers[start], func() {}
+	}
+	for i := range active {
+		n := active[(first+i)%len(active)]
+		select {
+		case p.slots[n] <- struct{}{}:
+			return p.servers[n], func() { <-p.slots[n] }
+		default:
+		}
+	}
+	p.slots[start] <- struct{}{}
+	return p.servers[start], func() { <-p.slots[start] }
+}
+
+// active returns the indexes of the servers in use, re-admitting dropped
+// ones whose cooldown has passed. When every server is dropped, all of them
+// are returned rather than none.
+func (p *resolverPool) active() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var active, all []int
+	for n := range p.servers {
+		all = append(all, n)
+		if p.droppedAt != nil && !p.droppedAt[n].IsZero() {
+			if p.cooldown == 0 || time.Since(p.droppedAt[n]) < p.cooldown {
+				continue
+			}
+			p.droppedAt[n] = time.Time{}
+			p.streaks[n] = 0
+			logf(warnColor, "Re-admitting resolver %s after %v", p.servers[n], p.cooldown)
+		}
+		active = append(active, n)
+	}
+	if len(active) == 0 {
+		return all
+	}
+	return active
+}
+
+// record counts a query to server for --stats and tracks its error streak
+// for --resolver-rotate-on-error, dropping it once the streak reaches the
+// threshold unless it is the last server in use. A not-found answer counts
+// as success.
+func (p *resolverPool) record(server string, err error) {
+	stats.addQuery(server)
+	if p.rotateAfter == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := slices.Index(p.servers, server)
+	if n < 0 || !p.droppedAt[n].IsZero() {
+		return
+	}
+	if err == nil || isNotFound(err) {
+		p.streaks[n] = 0
+		return
+	}
+	p.streaks[n]++
+	if p.streaks[n] < p.rotateAfter {
+		return
+	}
+	inUse := 0
+	for _, at := range p.droppedAt {
+		if at.IsZero() {
+			inUse++
+		}
+	}
+	if inUse > 1 {
+		p.droppedAt[n] = time.Now()
+		p.dropped = append(p.dropped, server)
+		logf(warnColor, "Dropping resolver %s after %d consecutive errors", server, p.streaks[n])
+	}
+}
+
+// hold takes a slot of the n-th server, if slots are limited, and returns a
+// function releasing it.
+func (p *resolverPool) hold(n int) (release func()) {
+	if p.slots == nil {
+		return func() {}
+	}
+	p.slots[n] <- struct{}{}
+	return func() { <-p.slots[n] }
 }
 
-func main() {
-	// Define command-line flags.
-	filePath := flag.String("file", "", "Path to a text file containing domain names (one domain per line).")
-	outputFormat := flag.String("format", "pretty", "Output format. Options: pretty (default), json, yaml, csv.")
-	noColor := flag.Bool("no-color", false, "Disable colored output and syntax highlighting.")
-	allRecords := flag.Bool("all", false, "Include all TXT records, even those without a valid key/value pair.")
-	// By default, SPF records are ignored unless --include-spf is set.
-	includeSPF := flag.Bool("include-spf", false, "Include SPF TXT records (records starting with 'v=spf1'). By default, SPF records are ignored.")
-	// New --simple flag: output a simplified view.
-	simple := flag.Bool("simple", false, "Output simplified results: only the domain and a simplified key (deduplicated).")
-
-	// Override the default Usage function with a Typer-inspired help interface.
-	flag.Usage = func() {
-		if *noColor {
-			color.NoColor = true
+// resolveWith runs query for name against the pool according to its
+// strategy. round-robin sends it to the next server in turn; first tries the
+// servers in order until one answers; fastest sends it to all of them and
+// takes the quickest answer; all waits for every server, returns the first
+// server's answer and, when the answers (compared as their sorted records)
+// differ, warns and records them for disagreement. A not-found error is an
+// answer; other errors make a server skipped.
+func resolveWith[T any](p *resolverPool, name, qtype string, query func(server string) (T, error), records func(T) []string) (T, error) {
+	var zero T
+	if len(p.servers) < 2 || p.strategy == "round-robin" {
+		server, release := p.acquire()
+		defer release()
+		ans, err := query(server)
+		p.record(server, err)
+		return ans, err
+	}
+	active := p.active()
+	answered := func(err error) bool { return err == nil || isNotFound(err) }
+	if p.strategy == "first" {
+		var firstErr error
+		for _, n := range active {
+			server := p.servers[n]
+			release := p.hold(n)
+			ans, err := query(server)
+			release()
+			p.record(server, err)
+			if answered(err) {
+				return ans, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if verbose {
+				log.Printf("Resolver %s failed for %s %s: %v", server, qtype, name, err)
+			}
 		}
-		header := color.New(color.FgCyan, color.Bold)
-		example := color.New(color.FgYellow)
-		fmt.Fprintf(os.Stderr, "\n")
-		header.Fprintf(os.Stderr, "dnxty - A DNS TXT Record Extraction Utility\n\n")
-		fmt.Fprintf(os.Stderr, "Usage:\n  %s [options] domain1 [domain2 ...]\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		printFlagDefaults()
-		fmt.Fprintf(os.Stderr, "\nExamples:\n")
-		example.Fprintf(os.Stderr, "  %s google.com facebook.com\n", os.Args[0])
-		example.Fprintf(os.Stderr, "  %s --file domains.txt --format json\n", os.Args[0])
-		example.Fprintf(os.Stderr, "  %s --all google.com\n", os.Args[0])
-		example.Fprintf(os.Stderr, "  %s --include-spf google.com\n", os.Args[0])
-		example.Fprintf(os.Stderr, "  %s --simple google.com\n\n", os.Args[0])
+		return zero, firstErr
 	}
 
-	flag.Parse()
-	color.NoColor = *noColor
-
-	if len(flag.Args()) == 0 {
-		fmt.Println("Usage: main.go [options] <domain>")
-		flag.PrintDefaults()
-		os.Exit(1)
+	// fastest and all query every server at once.
+	type answer struct {
+		n   int
+		ans T
+		err error
+	}
+	ch := make(chan answer, len(active))
+	for _, n := range active {
+		go func() {
+			release := p.hold(n)
+			defer release()
+			ans, err := query(p.servers[n])
+			p.record(p.servers[n], err)
+			ch <- answer{n, ans, err}
+		}()
+	}
+	answers := make([]*answer, len(p.servers))
+	var firstErr error
+	for range active {
+		a := <-ch
+		if !answered(a.err) {
+			if firstErr == nil {
+				firstErr = a.err
+			}
+			continue
+		}
+		if p.strategy == "fastest" {
+			return a.ans, a.err
+		}
+		answers[a.n] = &a
+	}
+	var result *answer
+	var views []string
+	var perServer []ResolverAnswer
+	distinct := make(map[string]bool)
+	for n, a := range answers {
+		if a == nil {
+			continue
+		}
+		if result == nil {
+			result = a
+		}
+		ra := ResolverAnswer{Resolver: p.servers[n], NotFound: a.err != nil}
+		view := "(no records)"
+		if a.err == nil {
+			ra.Records = records(a.ans)
+			sort.Strings(ra.Records)
+			view = fmt.Sprintf("%q", maskRecords(ra.Records))
+		}
+		distinct[view] = true
+		views = append(views, fmt.Sprintf("%s: %s", p.servers[n], view))
+		perServer = append(perServer, ra)
+	}
+	if result == nil {
+		return zero, firstErr
+	}
+	if len(distinct) > 1 {
+		logf(warnColor, "Resolvers disagree on %s %s: %s", qtype, name, strings.Join(views, "; "))
+		p.mu.Lock()
+		if p.disagreements == nil {
+			p.disagreements = make(map[string][]ResolverAnswer)
+		}
+		p.disagreements[disagreementKey(qtype, name)] = perServer
+		p.mu.Unlock()
 	}
+	return result.ans, result.err
+}
 
-	domain := flag.Args()[0]
+// txtSet returns a copy of txts for resolveWith to compare.
+func txtSet(txts []string) []string {
+	return append([]string(nil), txts...)
+}
 
-	if verbose {
-		log.Printf("Looking up domain: %s", domain)
-		if dnsServer != "" {
-			log.Printf("Using DNS server: %s", dnsServer)
+// answerRecords renders the answer section of r for resolveWith to compare:
+// TXT records as their text and others in presentation form without the
+// TTL, which differs between caches.
+func answerRecords(r *dns.Msg) []string {
+	var rrs []string
+	for _, rr := range r.Answer {
+		if txt, ok := rr.(*dns.TXT); ok {
+			var text strings.Builder
+			for _, seg := range txt.Txt {
+				text.WriteString(unescapeCharString(seg))
+			}
+			rrs = append(rrs, text.String())
+			continue
 		}
+		rr = dns.Copy(rr)
+		rr.Header().Ttl = 0
+		rrs = append(rrs, rr.String())
+	}
+	return rrs
+}
+
+// withDNSPort adds the default DNS port to server if it has none.
+func withDNSPort(server string) string {
+	if !strings.Contains(server, ":") {
+		return server + ":53"
 	}
+	return server
+}
+
+// runStats collects counters for the --stats summary. It is safe for
+// concurrent use.
+type runStats struct {
+	mu        sync.Mutex
+	lookups   int
+	failures  int
+	retries   int
+	durations []time.Duration
+	// first and last bound the lookups in time, for the query rate.
+	first, last time.Time
+	// queries counts the queries sent to each --resolvers server.
+	queries map[string]int
+}
+
+// stats holds the counters of the current run.
+var stats = &runStats{}
 
-	ips, err := lookupDomain(domain)
+// addLookup records a lookup attempt, how long it took and whether it
+// failed.
+func (s *runStats) addLookup(err error, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if start := now.Add(-elapsed); s.first.IsZero() || start.Before(s.first) {
+		s.first = start
+	}
+	s.last = now
+	s.lookups++
+	s.durations = append(s.durations, elapsed)
 	if err != nil {
-		log.Fatalf("Failed to lookup domain: %v", err)
+		s.failures++
 	}
+}
 
-	for _, ip := range ips {
-		fmt.Println(ip)
+// addQuery records a query sent to one of the --resolvers servers.
+func (s *runStats) addQuery(server string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queries == nil {
+		s.queries = make(map[string]int)
 	}
+	s.queries[server]++
+}
 
-	txts, err := lookupTXTRecords(domain)
-	if err != nil {
-		log.Printf("Error looking up TXT records for %s: %v", domain, err)
+// addRetry records a retried lookup.
+func (s *runStats) addRetry() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+}
+
+// print writes the stats summary to stderr as a single message.
+func (s *runStats) print(domains int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nStats:\n")
+	fmt.Fprintf(&b, "  Domains: %d\n", domains)
+	fmt.Fprintf(&b, "  Lookups: %d (%d failed)\n", s.lookups, s.failures)
+	budget.mu.Lock()
+	exhausted := budget.exhausted
+	budget.mu.Unlock()
+	if exhausted {
+		fmt.Fprintf(&b, "  Retries: %d (retry budget exhausted)\n", s.retries)
 	} else {
-		for _, txt := range txts {
-			fmt.Println(txt)
-		}
+		fmt.Fprintf(&b, "  Retries: %d\n", s.retries)
 	}
-
-	// Gather domains from file (if provided) and from positional arguments.
-	var domains []string
-	if *filePath != "" {
-		f, err := os.Open(*filePath)
-		if err != nil {
-			color.Red("Error opening file %s: %v", *filePath, err)
-			os.Exit(1)
-		}
-		defer f.Close()
-		scanner := bufio.NewScanner(f)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line != "" {
-				domains = append(domains, line)
+	if len(s.durations) > 0 {
+		sorted := append([]time.Duration(nil), s.durations...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		fmt.Fprintf(&b, "  Latency: p50 %v, p90 %v, p99 %v\n",
+			percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99))
+	}
+	// Rates are over the wall time from the first lookup to the last.
+	if wall := s.last.Sub(s.first); wall > 0 {
+		fmt.Fprintf(&b, "  Rate: %.1f lookups/s over %v\n", float64(s.lookups)/wall.Seconds(), wall.Round(time.Millisecond))
+		if len(resolvers.servers) > 1 {
+			var rates []string
+			for _, server := range resolvers.servers {
+				rates = append(rates, fmt.Sprintf("%s %.1f/s", server, float64(s.queries[server])/wall.Seconds()))
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			color.Red("Error reading file %s: %v", *filePath, err)
-			os.Exit(1)
+			fmt.Fprintf(&b, "  Per resolver: %s\n", strings.Join(rates, ", "))
 		}
 	}
-	// Append any domains provided as positional arguments.
-	domains = append(domains, flag.Args()...)
-	if len(domains) == 0 {
-		color.Yellow("No domains provided. Please supply domains as arguments or via the --file flag.\n")
-		flag.Usage()
-		os.Exit(1)
+	resolvers.mu.Lock()
+	dropped := resolvers.dropped
+	resolvers.mu.Unlock()
+	if len(dropped) > 0 {
+		fmt.Fprintf(&b, "  Dropped resolvers: %s\n", strings.Join(dropped, ", "))
 	}
+	logf(nil, "%s", b.String())
+}
 
-	// Prepare to store full results.
-	var results []DomainTXT
+// writeHeapProfile writes a heap profile of the live objects to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Collect garbage first so the profile reflects what is still in use.
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
 
-	// Compile a regex to capture key=value pairs (commonly used for domain verification).
-	re := regexp.MustCompile(`([\w\.\-]+)=([A-Za-z0-9\+\/=]+)`)
+// percentile returns the p-th percentile of sorted durations using the
+// nearest-rank method, rounded for display.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1].Round(time.Microsecond)
+}
 
-	// For each domain, perform a DNS TXT lookup.
-	for _, domain := range domains {
-		txtRecords, err := net.LookupTXT(domain)
-		if err != nil {
-			color.Red("Error looking up TXT records for %s: %v", domain, err)
-			continue
+// isNotFound reports whether err is a DNS error for a name that does not
+// exist, which retrying will not fix.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// errorCategory classifies a lookup error as not-found (NXDOMAIN or no
+// records), timeout, servfail, refused, dns-error or network.
+func errorCategory(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case isNotFound(err):
+		return "not-found"
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &opErr):
+		if opErr.Timeout() {
+			return "timeout"
 		}
-		// Process each TXT record.
-		for _, txt := range txtRecords {
-			// By default, ignore SPF records (those starting with "v=spf1") unless --include-spf is set.
-			if !*includeSPF && strings.HasPrefix(strings.ToLower(txt), "v=spf1") {
-				continue
-			}
-			key := ""
-			value := ""
-			match := re.FindStringSubmatch(txt)
-			if len(match) == 3 {
-				key = match[1]
-				value = match[2]
-			} else if *simple {
-				// If no key=value pattern is found and in simple mode,
-				// if the TXT record is a single word (no spaces or "="), use the entire record as the key.
-				if !strings.Contains(txt, " ") && !strings.Contains(txt, "=") {
-					key = txt
-				}
-			}
-			// If not in allRecords mode and key is empty, skip this record.
-			if !*allRecords && key == "" {
-				continue
-			}
-			results = append(results, DomainTXT{
-				Domain: domain,
-				TXT:    txt,
-				Key:    key,
-				Value:  value,
-			})
+		return "network"
+	case errors.As(err, &dnsErr):
+		switch {
+		case dnsErr.IsTimeout:
+			return "timeout"
+		case dnsErr.Err == "server misbehaving" || dnsErr.Err == "SERVFAIL":
+			return "servfail"
+		case dnsErr.Err == "REFUSED":
+			return "refused"
+		case strings.HasPrefix(dnsErr.Err, "dial ") || strings.HasPrefix(dnsErr.Err, "read ") || strings.HasPrefix(dnsErr.Err, "write "):
+			// The stdlib resolver flattens socket errors into Err.
+			return "network"
 		}
+		return "dns-error"
 	}
+	return "network"
+}
 
-	// If the --simple flag is enabled, produce simplified output.
-	if *simple {
-		// Create a map to deduplicate simplified keys per domain.
-		simpleMap := make(map[string]map[string]bool)
-		for _, res := range results {
-			if res.Key == "" {
-				continue
-			}
-			simpleKey := simplifyKey(res.Key)
-			if simpleMap[res.Domain] == nil {
-				simpleMap[res.Domain] = make(map[string]bool)
-			}
-			simpleMap[res.Domain][simpleKey] = true
+// LookupError is a failed lookup kept for the --defer-errors summary.
+type LookupError struct {
+	Domain   string
+	Type     string
+	Category string
+	Err      error
+}
+
+// printErrorSummary logs errs grouped by category, sorted by category and
+// then domain.
+func printErrorSummary(errs []LookupError) {
+	if len(errs) == 0 {
+		return
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Category != errs[j].Category {
+			return errs[i].Category < errs[j].Category
 		}
-		// Build a slice of SimpleResult.
-		var simpleResults []SimpleResult
-		for domain, keys := range simpleMap {
-			for key := range keys {
-				simpleResults = append(simpleResults, SimpleResult{
-					Domain: domain,
-					Key:    key,
-				})
+		if errs[i].Domain != errs[j].Domain {
+			return errs[i].Domain < errs[j].Domain
+		}
+		return errs[i].Type < errs[j].Type
+	})
+	logf(errorColor, "%d failed lookups:", len(errs))
+	for i, e := range errs {
+		if i == 0 || e.Category != errs[i-1].Category {
+			n := 0
+			for _, other := range errs[i:] {
+				if other.Category == e.Category {
+					n++
+				}
 			}
+			logf(errorColor, "  %s (%d):", e.Category, n)
 		}
+		logf(errorColor, "    %s %s: %v", e.Domain, e.Type, e.Err)
+	}
+}
 
-		// Output the simplified results in the chosen format.
-		switch strings.ToLower(*outputFormat) {
-		case "pretty":
-			printSimplePretty(simpleResults)
-		case "json":
-			printSimpleJSON(simpleResults)
-		case "yaml":
-			printSimpleYAML(simpleResults)
-		case "csv":
-			printSimpleCSV(simpleResults)
-		default:
-			color.Yellow("Unknown output format '%s'. Defaulting to pretty.", *outputFormat)
-			printSimplePretty(simpleResults)
+// withRetries calls lookup and retries failures up to --retries times with
+// exponential backoff. Every retry draws from the shared retry budget; once
+// it is exhausted, failures are returned without retrying.
+func withRetries(lookup func() error) error {
+	start := time.Now()
+	err := lookup()
+	stats.addLookup(err, time.Since(start))
+	backoff := retryBackoff
+	for attempt := 0; err != nil && attempt < retries && !isNotFound(err); attempt++ {
+		if !budget.take() {
+			break
 		}
-		return
+		stats.addRetry()
+		if verbose {
+			log.Printf("Retrying after error: %v", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		start = time.Now()
+		err = lookup()
+		stats.addLookup(err, time.Since(start))
 	}
+	return err
+}
 
-	// Otherwise, output the full results.
-	switch strings.ToLower(*outputFormat) {
-	case "pretty":
-		printPretty(results)
-	case "json":
-		printJSON(results)
-	case "yaml":
-		printYAML(results)
-	case "csv":
-		printCSV(results)
-	default:
-		color.Yellow("Unknown output format '%s'. Defaulting to pretty.", *outputFormat)
-		printPretty(results)
+// forEachDomain calls fn for every domain, running up to concurrency calls
+// at a time, and waits for all of them to finish.
+func forEachDomain(domains []string, concurrency int, fn func(i int, domain string)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i, domain)
+		}(i, domain)
 	}
+	wg.Wait()
 }
 
-// printPretty outputs the full results as a formatted table.
-func printPretty(results []DomainTXT) {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Domain", "TXT Record", "Key", "Value"})
-	headerColors := []tablewriter.Colors{
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
-	}
-	table.SetHeaderColor(headerColors...)
-	for _, r := range results {
-		table.Append([]string{r.Domain, r.TXT, r.Key, r.Value})
+// adaptWindow is the number of lookups --concurrency auto judges the failure
+// rate over before adjusting the limit.
+const adaptWindow = 20
+
+// adaptiveConcurrency is the limiter behind --concurrency auto. It starts at
+// four lookups per CPU and, after every adaptWindow lookups, halves the
+// limit when more than 10% of them failed or raises it by a quarter when
+// none did, staying between 1 and sixteen per CPU.
+type adaptiveConcurrency struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	min, max int
+	inFlight int
+	// done and failed count the lookups of the current window.
+	done, failed int
+}
+
+func newAdaptiveConcurrency(cpus int) *adaptiveConcurrency {
+	a := &adaptiveConcurrency{limit: 4 * cpus, min: 1, max: 16 * cpus}
+	a.cond = sync.NewCond(&a.mu)
+	return a
+}
+
+// forEach calls fn for every domain like forEachDomain, keeping at most
+// the current limit in flight. fn reports whether the lookup failed.
+func (a *adaptiveConcurrency) forEach(domains []string, fn func(i int, domain string) (failed bool)) {
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		a.mu.Lock()
+		for a.inFlight >= a.limit {
+			a.cond.Wait()
+		}
+		a.inFlight++
+		a.mu.Unlock()
+		wg.Add(1)
+		go func(i int, domain string) {
+			defer wg.Done()
+			a.release(fn(i, domain))
+		}(i, domain)
 	}
-	table.Render()
+	wg.Wait()
 }
 
-// printJSON outputs the full results in JSON format with syntax highlighting.
-func printJSON(results []DomainTXT) {
-	b, err := json.MarshalIndent(results, "", "  ")
-	if err != nil {
-		color.Red("Error marshalling JSON: %v", err)
-		return
+// release ends a lookup and, at the end of a window, adjusts the limit.
+func (a *adaptiveConcurrency) release(failed bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.inFlight--
+	a.done++
+	if failed {
+		a.failed++
 	}
-	jsonStr := string(b)
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, jsonStr, "json", "terminal", "monokai"); err != nil {
-			fmt.Println(jsonStr)
+	if a.done >= adaptWindow {
+		prev := a.limit
+		switch {
+		case a.failed*10 > a.done:
+			a.limit = max(a.limit/2, a.min)
+		case a.failed == 0:
+			a.limit = min(a.limit+max(a.limit/4, 1), a.max)
 		}
-	} else {
-		fmt.Println(jsonStr)
+		if verbose && a.limit != prev {
+			log.Printf("Concurrency auto: %d/%d lookups failed; limit %d -> %d", a.failed, a.done, prev, a.limit)
+		}
+		a.done, a.failed = 0, 0
 	}
+	a.cond.Broadcast()
 }
 
-// printYAML outputs the full results in YAML format with syntax highlighting.
-func printYAML(results []DomainTXT) {
-	b, err := yaml.Marshal(results)
+// lookupNAPTR queries the NAPTR records of name (e.g. an ENUM domain). The
+// service maps onto Key and the rewrite rule (regexp, or replacement when the
+// regexp is empty) onto Value.
+func lookupNAPTR(name string) ([]DomainTXT, error) {
+	r, err := queryRaw(name, dns.TypeNAPTR)
 	if err != nil {
-		color.Red("Error marshalling YAML: %v", err)
-		return
+		return nil, err
 	}
-	yamlStr := string(b)
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, yamlStr, "yaml", "terminal", "monokai"); err != nil {
-			fmt.Println(yamlStr)
+	var records []DomainTXT
+	for _, rr := range r.Answer {
+		naptr, ok := rr.(*dns.NAPTR)
+		if !ok {
+			continue
 		}
-	} else {
-		fmt.Println(yamlStr)
+		value := naptr.Regexp
+		if value == "" {
+			value = naptr.Replacement
+		}
+		records = append(records, DomainTXT{
+			Domain: name,
+			TXT: fmt.Sprintf("%d %d %q %q %q %s", naptr.Order, naptr.Preference,
+				naptr.Flags, naptr.Service, naptr.Regexp, naptr.Replacement),
+			Key:   naptr.Service,
+			Value: value,
+			Type:  "NAPTR",
+			NAPTR: &NAPTRRecord{
+				Order:       naptr.Order,
+				Preference:  naptr.Preference,
+				Flags:       naptr.Flags,
+				Service:     naptr.Service,
+				Regexp:      naptr.Regexp,
+				Replacement: naptr.Replacement,
+			},
+		})
 	}
+	return records, nil
 }
 
-// printCSV outputs the full results in CSV format with optional syntax highlighting.
-func printCSV(results []DomainTXT) {
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-	if err := writer.Write([]string{"Domain", "TXT Record", "Key", "Value"}); err != nil {
-		color.Red("Error writing CSV header: %v", err)
-		return
-	}
-	for _, r := range results {
-		if err := writer.Write([]string{r.Domain, r.TXT, r.Key, r.Value}); err != nil {
-			color.Red("Error writing CSV row: %v", err)
-			return
-		}
+// rawServer returns the address of the DNS server used for lower-level
+// queries: server if set, otherwise the first nameserver listed in
+// /etc/resolv.conf.
+func rawServer(server string) (string, error) {
+	if server != "" {
+		return server, nil
 	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		color.Red("Error flushing CSV: %v", err)
-		return
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no DNS server configured (use --dns): %v", err)
 	}
-	csvStr := buf.String()
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, csvStr, "csv", "terminal", "monokai"); err != nil {
-			fmt.Println(csvStr)
-		}
-	} else {
-		fmt.Println(csvStr)
+	if len(conf.Servers) == 0 {
+		return "", fmt.Errorf("no nameservers found in /etc/resolv.conf (use --dns)")
 	}
+	return net.JoinHostPort(conf.Servers[0], conf.Port), nil
 }
 
-// The following functions output simplified results.
-
-func printSimplePretty(simpleResults []SimpleResult) {
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Domain", "Key"})
-	headerColors := []tablewriter.Colors{
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
-		{tablewriter.FgHiBlueColor, tablewriter.Bold},
+// resolvConfServers returns the nameservers listed in the resolv.conf file
+// at path as host:port addresses.
+func resolvConfServers(path string) ([]string, error) {
+	conf, err := dns.ClientConfigFromFile(path)
+	if err != nil {
+		return nil, err
 	}
-	table.SetHeaderColor(headerColors...)
-	for _, r := range simpleResults {
-		table.Append([]string{r.Domain, r.Key})
+	if len(conf.Servers) == 0 {
+		return nil, fmt.Errorf("no nameserver lines found")
 	}
-	table.Render()
+	servers := make([]string, len(conf.Servers))
+	for i, server := range conf.Servers {
+		servers[i] = net.JoinHostPort(server, conf.Port)
+	}
+	return servers, nil
 }
 
-func printSimpleJSON(simpleResults []SimpleResult) {
-	b, err := json.MarshalIndent(simpleResults, "", "  ")
-	if err != nil {
-		color.Red("Error marshalling JSON: %v", err)
-		return
+// queryRaw sends a single query for name and qtype straight to the DNS server,
+// bypassing net.Resolver so answers arrive exactly as the server sent them
+// (e.g. TXT records keep their individual character-strings).
+func queryRaw(name string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	if ecsSubnet != nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		ones, _ := ecsSubnet.Mask.Size()
+		family := uint16(1)
+		if ecsSubnet.IP.To4() == nil {
+			family = 2
+		}
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        family,
+			SourceNetmask: uint8(ones),
+			Address:       ecsSubnet.IP,
+		})
 	}
-	jsonStr := string(b)
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, jsonStr, "json", "terminal", "monokai"); err != nil {
-			fmt.Println(jsonStr)
+	r, err := resolveWith(resolvers, name, dns.TypeToString[qtype], func(server string) (*dns.Msg, error) {
+		server, err := rawServer(server)
+		if err != nil {
+			return nil, err
 		}
-	} else {
-		fmt.Println(jsonStr)
+		r, err := exchange(server, m.Copy())
+		if err != nil {
+			return nil, err
+		}
+		if r.Rcode != dns.RcodeSuccess {
+			return nil, &net.DNSError{
+				Err:        dns.RcodeToString[r.Rcode],
+				Name:       name,
+				Server:     server,
+				IsNotFound: r.Rcode == dns.RcodeNameError,
+			}
+		}
+		return r, nil
+	}, answerRecords)
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		log.Printf("Raw response for %s:\n%s", name, r)
 	}
+	return r, nil
 }
 
-func printSimpleYAML(simpleResults []SimpleResult) {
-	b, err := yaml.Marshal(simpleResults)
+// printRawTXT prints every TXT answer for domain with each character-string
+// quoted separately, mirroring the presentation format used by dig.
+func printRawTXT(domain string) error {
+	r, err := queryRaw(domain, dns.TypeTXT)
 	if err != nil {
-		color.Red("Error marshalling YAML: %v", err)
-		return
+		return err
 	}
-	yamlStr := string(b)
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, yamlStr, "yaml", "terminal", "monokai"); err != nil {
-			fmt.Println(yamlStr)
+	for _, rr := range r.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
 		}
-	} else {
-		fmt.Println(yamlStr)
+		segments := txt.Txt
+		if redact {
+			// Mask the record as a whole, so that values split across
+			// character-strings are caught.
+			segments = splitLike(redactText(strings.Join(segments, "")), segments)
+		}
+		fmt.Fprintf(output, "%s\t\"%s\"\n", domain, strings.Join(segments, "\" \""))
 	}
+	return nil
 }
 
-func printSimpleCSV(simpleResults []SimpleResult) {
-	var buf bytes.Buffer
-	writer := csv.NewWriter(&buf)
-	if err := writer.Write([]string{"Domain", "Key"}); err != nil {
-		color.Red("Error writing CSV header: %v", err)
-		return
+// syslogPriority is facility local0, severity informational.
+const syslogPriority = 16*8 + 6
+
+// sendSyslog sends each result as an RFC 5424 message with a JSON body to the
+// collector at addr ("udp://host:port", "tcp://host:port" or "host:port" for
+// UDP). Messages over TCP use octet-counting framing.
+func sendSyslog(addr string, results []DomainTXT) error {
+	network := "udp"
+	if n, rest, ok := strings.Cut(addr, "://"); ok {
+		network, addr = n, rest
 	}
-	for _, r := range simpleResults {
-		if err := writer.Write([]string{r.Domain, r.Key}); err != nil {
-			color.Red("Error writing CSV row: %v", err)
-			return
-		}
+	if network != "udp" && network != "tcp" {
+		return fmt.Errorf("unsupported syslog network %q (use udp or tcp)", network)
 	}
-	writer.Flush()
-	if err := writer.Error(); err != nil {
-		color.Red("Error flushing CSV: %v", err)
-		return
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return err
 	}
-	csvStr := buf.String()
-	if !color.NoColor {
-		if err := quick.Highlight(os.Stdout, csvStr, "csv", "terminal", "monokai"); err != nil {
-			fmt.Println(csvStr)
+	defer conn.Close()
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	for _, r := range results {
+		body, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		msg := fmt.Sprintf("<%d>1 %s %s dnxty %d - - %s", syslogPriority,
+			time.Now().UTC().Format(time.RFC3339Nano), hostname, os.Getpid(), body)
+		if network == "tcp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := io.WriteString(conn, msg); err != nil {
+			return err
 		}
-	} else {
-		fmt.Println(csvStr)
 	}
+	return nil
 }
 
-func lookupDomain(domain string) ([]string, error) {
-	resolver := createResolver()
-	ips, err := resolver.LookupHost(context.Background(), domain)
+// exchange sends m to server and returns the response, honouring --timeout,
+// --dial-timeout and --socks5.
+func exchange(server string, m *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Timeout: queryTimeout.forType(dns.TypeToString[m.Question[0].Qtype]), DialTimeout: dialTimeout}
+	if socks5Proxy == "" {
+		client.Dialer = newDialer("udp")
+		r, _, err := client.Exchange(m, server)
+		// A truncated UDP answer is missing records; ask again over TCP.
+		if err == nil && r.Truncated {
+			if verbose {
+				log.Printf("Truncated UDP answer from %s for %s; retrying over TCP", server, m.Question[0].Name)
+			}
+			client.Net = "tcp"
+			client.Dialer = newDialer("tcp")
+			r, _, err = client.Exchange(m, server)
+		}
+		return r, err
+	}
+	conn, err := dialDNS(context.Background(), "tcp", server)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+	client.Net = "tcp"
+	r, _, err := client.ExchangeWithConn(m, &dns.Conn{Conn: conn})
+	return r, err
+}
 
-	if verbose {
-		log.Printf("Resolved IPs: %v", ips)
+// healthCheckDomain is queried by --check-resolvers. Any answer, even
+// NXDOMAIN, shows the resolver is up.
+const healthCheckDomain = "example.com"
+
+// checkResolvers sends a test query to each configured resolver (or the
+// system one), reporting per-resolver latency on stderr, and returns the
+// number of resolvers that did not answer.
+func checkResolvers() int {
+	servers := resolvers.servers
+	if len(servers) == 0 {
+		server, err := rawServer(dnsServer)
+		if err != nil {
+			logf(errorColor, "Resolver check failed: %v", err)
+			return 1
+		}
+		servers = []string{server}
+	}
+	failed := 0
+	for _, server := range servers {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(healthCheckDomain), dns.TypeTXT)
+		start := time.Now()
+		r, err := exchange(server, m)
+		elapsed := time.Since(start).Round(time.Microsecond)
+		if err != nil {
+			logf(errorColor, "Resolver %s: FAILED after %v: %v", server, elapsed, err)
+			failed++
+			continue
+		}
+		logf(nil, "Resolver %s: ok (%s in %v)", server, dns.RcodeToString[r.Rcode], elapsed)
 	}
+	return failed
+}
 
-	return ips, nil
+// createResolver returns the resolver used for stdlib lookups: one that
+// dials server, or the system nameservers when server is empty, honouring
+// --dial-timeout and --socks5.
+func createResolver(server string) *net.Resolver {
+	if server == "" && dialTimeout == 0 && socks5Proxy == "" && sourceIP == nil {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		// Keep the network the resolver asks for: it retries truncated UDP
+		// answers over TCP.
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if server != "" {
+				address = server
+			}
+			return dialDNS(ctx, network, address)
+		},
+	}
 }
 
-func lookupTXTRecords(domain string) ([]string, error) {
-	resolver := createResolver()
-	txts, err := resolver.LookupTXT(context.Background(), domain)
-	if err != nil {
-		return nil, err
+// newDialer returns a dialer for network honouring --dial-timeout and, when
+// set, binding to the --source-ip address.
+func newDialer(network string) *net.Dialer {
+	d := &net.Dialer{Timeout: dialTimeout}
+	if sourceIP != nil {
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: sourceIP}
+		} else {
+			d.LocalAddr = &net.TCPAddr{IP: sourceIP}
+		}
 	}
+	return d
+}
 
-	if verbose {
-		log.Printf("Resolved TXT records: %v", txts)
+// isLocalIP reports whether ip is assigned to one of this host's interfaces.
+func isLocalIP(ip net.IP) (bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true, nil
+		}
 	}
+	return false, nil
+}
 
-	return txts, nil
+// dialDNS connects to a DNS server, honouring --dial-timeout. With --socks5
+// the connection is made over TCP through the proxy, since SOCKS5 proxies
+// do not relay UDP.
+func dialDNS(ctx context.Context, network, address string) (net.Conn, error) {
+	if socks5Proxy == "" {
+		return newDialer(network).DialContext(ctx, network, address)
+	}
+	p, err := proxy.SOCKS5("tcp", socks5Proxy, nil, newDialer("tcp"))
+	if err != nil {
+		return nil, err
+	}
+	return p.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
 }
 
-func createResolver() *net.Resolver {
-	if dnsServer != "" {
-		if !strings.Contains(dnsServer, ":") {
-			dnsServer += ":53"
-		}
-		return &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
-				d := net.Dialer{}
-				return d.DialContext(ctx, "udp", dnsServer)
-			},
-		}
+// queryContext returns the context for a single lookup of the given record
+// type, bounded by --timeout when it is set.
+func queryContext(qtype string) (context.Context, context.CancelFunc) {
+	if d := queryTimeout.forType(qtype); d > 0 {
+		return context.WithTimeout(context.Background(), d)
 	}
-	return net.DefaultResolver
+	return context.WithCancel(context.Background())
 }
@@ -0,0 +1,1793 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestMain runs the CLI instead of the tests when DNXTY_TEST_MAIN is set,
+// which is how runDnxty executes dnxty end to end in a subprocess.
+func TestMain(m *testing.M) {
+	if os.Getenv("DNXTY_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	// In-process tests may log through the printer goroutine.
+	startPrinter()
+	os.Exit(m.Run())
+}
+
+// startDNS serves the records of zone (in zone-file syntax) on a local UDP
+// port for the duration of the test and returns its address. Names without
+// records are answered from the closest *. wildcard above them, if any, and
+// get NXDOMAIN otherwise.
+func startDNS(t *testing.T, zone string) string {
+	t.Helper()
+	return startDNSHook(t, zone, nil)
+}
+
+// startDNSHook is startDNS calling hook, if not nil, with the name of every
+// query before answering it.
+func startDNSHook(t *testing.T, zone string, hook func(name string)) string {
+	t.Helper()
+	records := make(map[string][]dns.RR)
+	zp := dns.NewZoneParser(strings.NewReader(zone), "", "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		name := strings.ToLower(rr.Header().Name)
+		records[name] = append(records[name], rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("parsing test zone: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		q := req.Question[0]
+		if hook != nil {
+			hook(q.Name)
+		}
+		rrs, ok := records[strings.ToLower(q.Name)]
+		for name := q.Name; !ok; {
+			_, parent, more := strings.Cut(name, ".")
+			if !more || parent == "" {
+				m.Rcode = dns.RcodeNameError
+				break
+			}
+			rrs, ok = records["*."+strings.ToLower(parent)]
+			name = parent
+		}
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == q.Qtype {
+				rr = dns.Copy(rr)
+				rr.Header().Name = q.Name
+				m.Answer = append(m.Answer, rr)
+			}
+		}
+		w.WriteMsg(m)
+	})
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// runDnxty runs dnxty with args against a DNS server serving zone and
+// returns its standard output and error.
+func runDnxty(t *testing.T, zone string, args ...string) (stdout, stderr string) {
+	t.Helper()
+	return runCLI(t, append([]string{"--dns", startDNS(t, zone)}, args...)...)
+}
+
+// runCLI runs dnxty with args and returns its standard output and error.
+func runCLI(t *testing.T, args ...string) (stdout, stderr string) {
+	t.Helper()
+	args = append([]string{"--no-color"}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "DNXTY_TEST_MAIN=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("dnxty %s: %v\n%s", strings.Join(args, " "), err, errOut.String())
+	}
+	return out.String(), errOut.String()
+}
+
+func TestRedactMasksEveryRecordField(t *testing.T) {
+	zone := `
+plain.test.     300 IN TXT "site-verification=AAAASECRETPLAINBBBB"
+split.test.     300 IN TXT "site-verification=AAAASECRET" "SPLITBBBB"
+spaces.test.    300 IN TXT "  site-verification=AAAASECRETSPACESBBBB   trailer "
+spf.test.       300 IN TXT "v=spf1   ip4:192.0.2.1 exp=AAAASECRETSPFBBBB ~all"
+unicode.test.   300 IN TXT "caf\101\204\129 site-verification=AAAASECRETNFDBBBB"
+`
+	domains := []string{"plain.test", "split.test", "spaces.test", "spf.test", "unicode.test"}
+	for _, tc := range []struct {
+		name  string
+		flags []string
+	}{
+		{"compat-dig", []string{"--compat-dig"}},
+		{"compress-values", []string{"--compress-values"}},
+		{"normalize-spf", []string{"--include-spf", "--normalize-spf"}},
+		{"normalize-unicode", []string{"--normalize-unicode"}},
+		{"all", []string{"--all", "--include-spf", "--compat-dig", "--compress-values", "--normalize-spf", "--normalize-unicode"}},
+	} {
+		for _, format := range []string{"json", "csv", "yaml", "pretty"} {
+			t.Run(tc.name+"/"+format, func(t *testing.T) {
+				args := append([]string{"--redact", "--format", format}, tc.flags...)
+				stdout, stderr := runDnxty(t, zone, append(args, domains...)...)
+				if !strings.Contains(stdout, "AAAA") {
+					t.Fatalf("no results in output:\n%s%s", stdout, stderr)
+				}
+				if strings.Contains(stdout+stderr, "SECRET") {
+					t.Errorf("secret leaked with %v:\n%s%s", args, stdout, stderr)
+				}
+			})
+		}
+	}
+}
+
+func TestRedactMasksOutputOutsideResults(t *testing.T) {
+	zone := `
+plain.test. 300 IN TXT "site-verification=AAAASECRETPLAINBBBB"
+split.test. 300 IN TXT "site-verification=AAAASECRET" "SPLITBBBB"
+`
+	other := startDNS(t, `plain.test. 300 IN TXT "site-verification=AAAASECRETOTHERBBBB"`)
+	disagree := []string{"--resolvers", startDNS(t, zone) + "," + other, "--resolver-strategy", "all"}
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"raw", []string{"--dns", startDNS(t, zone), "--raw"}},
+		{"explain", []string{"--dns", startDNS(t, zone), "--explain"}},
+		{"verbose", []string{"--dns", startDNS(t, zone), "--verbose"}},
+		{"disagreement warning", disagree},
+		{"disagreements report", append([]string{"--disagreements", "--format", "csv"}, disagree...)},
+	} {
+		stdout, stderr := runCLI(t, append(append([]string{"--redact"}, tc.args...), "plain.test", "split.test")...)
+		if !strings.Contains(stdout+stderr, "AAAA") {
+			t.Errorf("%s: no records in output:\n%s%s", tc.name, stdout, stderr)
+		}
+		if strings.Contains(stdout+stderr, "SECRET") {
+			t.Errorf("%s: secret leaked:\n%s%s", tc.name, stdout, stderr)
+		}
+	}
+}
+
+func TestUnescapeCharString(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`say \"hi\"`, `say "hi"`},
+		{`back\\slash`, `back\slash`},
+		{`tab\009end`, "tab\tend"},
+		{`caf\195\169`, "café"},
+		{`\256`, "256"},
+		{`\12`, "12"},
+		{`trailing\`, `trailing\`},
+	} {
+		if got := unescapeCharString(tc.in); got != tc.want {
+			t.Errorf("unescapeCharString(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDigTXT(t *testing.T) {
+	for _, tc := range []struct {
+		segments []string
+		want     string
+	}{
+		{[]string{"v=spf1 -all"}, `"v=spf1 -all"`},
+		{[]string{"first", "second"}, `"first" "second"`},
+		{[]string{`a "quoted" \ value`}, `"a \"quoted\" \\ value"`},
+		{[]string{"tab\there", "café"}, `"tab\009here" "caf\195\169"`},
+		{[]string{""}, `""`},
+	} {
+		if got := digTXT(tc.segments); got != tc.want {
+			t.Errorf("digTXT(%q) = %s, want %s", tc.segments, got, tc.want)
+		}
+	}
+}
+
+func TestSplitLike(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		segments []string
+		want     []string
+	}{
+		{"abcdef", []string{"abc", "def"}, []string{"abc", "def"}},
+		{"abcdefgh", []string{"abc", "def"}, []string{"abc", "defgh"}},
+		{"abcd", []string{"abc", "def"}, []string{"abc", "d"}},
+		{"ab", []string{"abc", "def", "g"}, []string{"ab", "", ""}},
+		{"abc", []string{"xyz"}, []string{"abc"}},
+	} {
+		if got := splitLike(tc.s, tc.segments); !slices.Equal(got, tc.want) {
+			t.Errorf("splitLike(%q, %q) = %q, want %q", tc.s, tc.segments, got, tc.want)
+		}
+	}
+}
+
+func TestRecordSetHashes(t *testing.T) {
+	published := map[string][]string{
+		"a.test":     {"v=spf1 -all", "key=value"},
+		"b.test":     {"key=value", "v=spf1 -all", "key=value"},
+		"c.test":     {"key=other", "v=spf1 -all"},
+		"empty.test": nil,
+	}
+	hashes := recordSetHashes([]string{"a.test", "b.test", "c.test", "empty.test", "failed.test"}, published)
+	if len(hashes) != 4 {
+		t.Fatalf("got %d hashes, want 4 (failed lookups are left out): %v", len(hashes), hashes)
+	}
+	if hashes[0].Hash != hashes[1].Hash {
+		t.Errorf("record order and duplicates changed the hash: %v", hashes[:2])
+	}
+	if hashes[0].Hash == hashes[2].Hash {
+		t.Errorf("different record sets hashed the same: %v", hashes)
+	}
+	// The hash of no records is the sha256 of empty input.
+	if want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"; hashes[3].Hash != want {
+		t.Errorf("empty record set hash = %s, want %s", hashes[3].Hash, want)
+	}
+}
+
+func TestHashIgnoresFilters(t *testing.T) {
+	zone := `
+example.test. 300 IN TXT "v=spf1 include:_spf.example.test ~all"
+example.test. 300 IN TXT "google-site-verification=abc123"
+example.test. 300 IN TXT "free text"
+example.test. 300 IN TXT "k=" "v"
+`
+	var want string
+	for _, flags := range [][]string{
+		nil,
+		{"--all"},
+		{"--include-spf"},
+		{"--all", "--include-spf", "--normalize-spf", "--compress-values"},
+		{"--max-txt-per-query", "1"},
+		{"--redact"},
+	} {
+		args := append([]string{"--hash", "--format", "csv"}, flags...)
+		got, _ := runDnxty(t, zone, append(args, "example.test")...)
+		if want == "" {
+			want = got
+			if !strings.Contains(got, "example.test,") {
+				t.Fatalf("no hash in output:\n%s", got)
+			}
+		} else if got != want {
+			t.Errorf("--hash with %v = %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	for _, tc := range []struct {
+		path, want string
+	}{
+		{"results.json", "json"},
+		{"results.JSON", "json"},
+		{"results.jsonl", "ndjson"},
+		{"results.ndjson.gz", "ndjson"},
+		{"results.yml", "yaml"},
+		{"results.csv.gz", "csv"},
+		{"notes.org", "org"},
+		{"report.md", "markdown"},
+		{"report.markdown", "markdown"},
+		{"table.txt", "pretty"},
+		{"results.out", "fallback"},
+		{"results", "fallback"},
+	} {
+		if got := formatForPath(tc.path, "fallback"); got != tc.want {
+			t.Errorf("formatForPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPrintMarkdownTable(t *testing.T) {
+	var b bytes.Buffer
+	defer func(w io.Writer) { output = w }(output)
+	output = &b
+	printMarkdownTable([]string{"Domain", "Value"}, [][]string{
+		{"example.test", "a|b"},
+		{"example.test", `back\slash`},
+		{"example.test", "two\nlines"},
+		{"example.test", ""},
+	})
+	want := `| Domain | Value |
+| --- | --- |
+| example.test | a\|b |
+| example.test | back\\slash |
+| example.test | two<br>lines |
+| example.test |  |
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestSummaryCountsPublishedRecords(t *testing.T) {
+	zone := `
+example.test. 300 IN TXT "v=spf1 -all"
+example.test. 300 IN TXT "google-site-verification=abc123"
+example.test. 300 IN TXT "free text"
+`
+	for _, tc := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "example.test,3,google-site-verification,yes,no\n"},
+		{[]string{"--all", "--include-spf"}, "example.test,3,\""},
+		{[]string{"--max-txt-per-query", "1"}, "example.test,3,"},
+	} {
+		args := append([]string{"--summary", "--format", "csv"}, tc.flags...)
+		got, _ := runDnxty(t, zone, append(args, "example.test")...)
+		if !strings.Contains(got, tc.want) {
+			t.Errorf("--summary with %v:\n%s\nwant a row starting %q", tc.flags, got, tc.want)
+		}
+	}
+}
+
+func TestBenchmarkReportsEachResolver(t *testing.T) {
+	var zone strings.Builder
+	for _, domain := range benchmarkDomains {
+		fmt.Fprintf(&zone, "%s. 300 IN TXT \"v=spf1 -all\"\n", domain)
+	}
+	good, empty := startDNS(t, zone.String()), startDNS(t, "")
+	got, _ := runCLI(t, "--benchmark", "--format", "csv", "--resolvers", good+","+empty)
+	lines := strings.Split(strings.TrimSpace(got), "\n")
+	want := []string{
+		"Resolver,Queries,Succeeded,Success Rate,Avg Latency",
+		good + ",8,8,100.0%,",
+		empty + ",8,0,0.0%,0.0ms",
+		"all,16,8,50.0%,",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), got)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("line %d = %q, want prefix %q", i, lines[i], prefix)
+		}
+	}
+
+	got, _ = runCLI(t, "--benchmark", "--format", "csv", "--dns", good)
+	if lines := strings.Split(strings.TrimSpace(got), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], good+",8,8,") {
+		t.Errorf("single resolver benchmark:\n%s", got)
+	}
+}
+
+func TestEmptyValuesAndAll(t *testing.T) {
+	zone := `
+example.test. 300 IN TXT "token="
+example.test. 300 IN TXT "key=value"
+example.test. 300 IN TXT "free text"
+example.test. 300 IN TXT "a= b=2"
+`
+	for _, tc := range []struct {
+		name  string
+		flags []string
+		want  []string
+	}{
+		{"default", nil, []string{
+			"example.test,a= b=2,b,2",
+			"example.test,key=value,key,value",
+		}},
+		{"all", []string{"--all"}, []string{
+			"example.test,a= b=2,b,2",
+			"example.test,free text,,",
+			"example.test,key=value,key,value",
+			"example.test,token=,,",
+		}},
+		{"include-empty-value", []string{"--include-empty-value"}, []string{
+			"example.test,a= b=2,a,",
+			"example.test,a= b=2,b,2",
+			"example.test,key=value,key,value",
+			"example.test,token=,token,",
+		}},
+		{"all and include-empty-value", []string{"--all", "--include-empty-value"}, []string{
+			"example.test,a= b=2,a,",
+			"example.test,a= b=2,b,2",
+			"example.test,free text,,",
+			"example.test,key=value,key,value",
+			"example.test,token=,token,",
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"--format", "csv"}, tc.flags...)
+			got, _ := runDnxty(t, zone, append(args, "example.test")...)
+			lines := strings.Split(strings.TrimSpace(got), "\n")[1:]
+			slices.Sort(lines)
+			if !slices.Equal(lines, tc.want) {
+				t.Errorf("got rows:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(tc.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCanonicalSPF(t *testing.T) {
+	for _, tc := range []struct {
+		record string
+		sorted bool
+		want   string
+	}{
+		{"v=spf1 -all", false, "v=spf1 -all"},
+		{"V=SPF1   ip4:192.0.2.1\tInclude:_spf.Example.com  ~ALL", false, "v=spf1 +ip4:192.0.2.1 +include:_spf.example.com ~all"},
+		{"v=spf1 mx a ?all", false, "v=spf1 +mx +a ?all"},
+		{"v=spf1 redirect=_spf.example.com", false, "v=spf1 redirect=_spf.example.com"},
+		{"v=spf1 exp=explain.example.com mx -all", false, "v=spf1 exp=explain.example.com +mx -all"},
+		{"v=spf1 mx include:b.test include:a.test -all", true, "v=spf1 +include:a.test +include:b.test +mx -all"},
+		{"v=spf1 redirect=r.test exp=e.test ip4:192.0.2.1 ~all a", true, "v=spf1 +a +ip4:192.0.2.1 ~all exp=e.test redirect=r.test"},
+	} {
+		if got := canonicalSPF(tc.record, tc.sorted); got != tc.want {
+			t.Errorf("canonicalSPF(%q, %v) = %q, want %q", tc.record, tc.sorted, got, tc.want)
+		}
+	}
+}
+
+func TestTypeTimeoutsSet(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		def     time.Duration
+		byType  map[string]time.Duration
+		wantErr bool
+	}{
+		{value: "5s", def: 5 * time.Second},
+		{value: "0", def: 0},
+		{value: "TXT=10s", byType: map[string]time.Duration{"TXT": 10 * time.Second}},
+		{value: "5s, txt=10s ,CAA=500ms", def: 5 * time.Second,
+			byType: map[string]time.Duration{"TXT": 10 * time.Second, "CAA": 500 * time.Millisecond}},
+		{value: "TXT=1s,TXT=2s", byType: map[string]time.Duration{"TXT": 2 * time.Second}},
+		{value: "5", wantErr: true},
+		{value: "TXT=", wantErr: true},
+		{value: "=5s", wantErr: true},
+		{value: "-1s", wantErr: true},
+		{value: "TXT=-1s", wantErr: true},
+	} {
+		var got typeTimeouts
+		err := got.Set(tc.value)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) = nil error, want an error", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): %v", tc.value, err)
+			continue
+		}
+		if got.def != tc.def || !maps.Equal(got.byType, tc.byType) {
+			t.Errorf("Set(%q) = %v %v, want %v %v", tc.value, got.def, got.byType, tc.def, tc.byType)
+		}
+	}
+
+	var timeouts typeTimeouts
+	if err := timeouts.Set("3s,TXT=10s"); err != nil {
+		t.Fatal(err)
+	}
+	if got := timeouts.forType("TXT"); got != 10*time.Second {
+		t.Errorf("forType(TXT) = %v, want 10s", got)
+	}
+	if got := timeouts.forType("MX"); got != 3*time.Second {
+		t.Errorf("forType(MX) = %v, want the 3s default", got)
+	}
+	if got := timeouts.String(); got != "3s,TXT=10s" {
+		t.Errorf("String() = %q, want %q", got, "3s,TXT=10s")
+	}
+}
+
+func TestFlattenResults(t *testing.T) {
+	r := func(domain, key, value string) DomainTXT {
+		return DomainTXT{Domain: domain, Key: key, Value: value}
+	}
+	for _, tc := range []struct {
+		name    string
+		results []DomainTXT
+		want    map[string]string
+	}{
+		{"single keys", []DomainTXT{r("a.test", "google", "g1"), r("a.test", "ms", "m1"), r("b.test", "google", "g2")},
+			map[string]string{"a.test.google": "g1", "a.test.ms": "m1", "b.test.google": "g2"}},
+		{"repeated key is indexed", []DomainTXT{r("a.test", "ms", "one"), r("a.test", "ms", "two")},
+			map[string]string{"a.test.ms.0": "one", "a.test.ms.1": "two"}},
+		{"records without a key are omitted", []DomainTXT{r("a.test", "", ""), r("a.test", "k", "v")},
+			map[string]string{"a.test.k": "v"}},
+		{"dotted names colliding across domains", []DomainTXT{r("a.b.test", "c", "first"), r("a", "b.test.c", "second")},
+			map[string]string{"a.b.test.c.0": "first", "a.b.test.c.1": "second"}},
+		{"key colliding with an index", []DomainTXT{r("a.test", "ms", "one"), r("a.test", "ms", "two"), r("a.test", "ms.0", "clash")},
+			map[string]string{"a.test.ms.0": "one", "a.test.ms.1": "two", "a.test.ms.0.0": "clash"}},
+		{"index skipping a taken name", []DomainTXT{r("a.test", "ms.0", "clash"), r("a.test", "ms", "one"), r("a.test", "ms", "two")},
+			map[string]string{"a.test.ms.0": "clash", "a.test.ms.1": "one", "a.test.ms.2": "two"}},
+	} {
+		if got := flattenResults(tc.results); !maps.Equal(got, tc.want) {
+			t.Errorf("%s: flattenResults = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// useDNS points in-process lookups at a DNS server serving zone for the
+// rest of the test.
+func useDNS(t *testing.T, zone string) {
+	t.Helper()
+	saved := dnsServer
+	dnsServer = startDNS(t, zone)
+	t.Cleanup(func() { dnsServer = saved })
+}
+
+// findingTerms renders findings as "severity term" strings for comparison.
+func findingTerms(findings []Finding) []string {
+	terms := make([]string, len(findings))
+	for i, f := range findings {
+		terms[i] = f.Severity + " " + f.Term
+	}
+	return terms
+}
+
+func TestValidateDMARC(t *testing.T) {
+	useDNS(t, `
+_dmarc.good.test.        300 IN TXT "v=DMARC1; p=reject; rua=mailto:dmarc@good.test"
+_dmarc.monitor.test.     300 IN TXT "v=DMARC1; p=none; pct=50; rua=mailto:d@reports.monitor.test"
+_dmarc.bad.test.         300 IN TXT "v=DMARC1; p=block; pct=150; adkim=x; ri=soon; fo=2; rf=iodef; foo=bar; p=none"
+_dmarc.nop.test.         300 IN TXT "v=DMARC1; sp=reject; rua=dmarc@nop.test"
+_dmarc.version.test.     300 IN TXT "v=dmarc1; p=reject; rua=mailto:d@version.test; v=DMARC1"
+_dmarc.twice.test.       300 IN TXT "v=DMARC1; p=reject"
+_dmarc.twice.test.       300 IN TXT "v=DMARC1; p=none"
+_dmarc.external.test.    300 IN TXT "v=DMARC1; p=reject; rua=mailto:a@vendor.test,mailto:b@other.test!10m"
+external.test._report._dmarc.vendor.test. 300 IN TXT "v=DMARC1"
+_dmarc.broken.test.      300 IN TXT "v=DMARC1; p=reject; rua=mailto:d@bad_domain!.test; junk"
+`)
+	for _, tc := range []struct {
+		domain string
+		want   []string
+	}{
+		{"good.test", []string{}},
+		{"monitor.test", []string{"info p=none", "info pct=50"}},
+		{"bad.test", []string{
+			"error p=block", "error pct=150", "error adkim=x", "error ri=soon", "error fo=2",
+			"warning rf=iodef", "warning foo=bar", "error p=none", "info p=none", "info ",
+		}},
+		{"nop.test", []string{"error rua=dmarc@nop.test", "error "}},
+		{"version.test", []string{"error v=dmarc1", "error v=DMARC1"}},
+		{"twice.test", []string{"error "}},
+		{"missing.test", []string{"warning "}},
+		{"external.test", []string{"error rua=mailto:a@vendor.test,mailto:b@other.test!10m"}},
+		{"broken.test", []string{"error rua=mailto:d@bad_domain!.test", "error junk"}},
+	} {
+		v := validateDMARC(tc.domain)
+		if v.Error != "" {
+			t.Errorf("validateDMARC(%s): %s", tc.domain, v.Error)
+			continue
+		}
+		if got := findingTerms(v.Findings); !slices.Equal(got, tc.want) {
+			t.Errorf("validateDMARC(%s) findings = %q, want %q\n%v", tc.domain, got, tc.want, v.Findings)
+		}
+	}
+}
+
+func TestCSVStreamsRowsAsDomainsFinish(t *testing.T) {
+	zone := `
+fast.test. 300 IN TXT "key=fast"
+slow.test. 300 IN TXT "key=slow"
+`
+	release := make(chan struct{})
+	var once sync.Once
+	defer once.Do(func() { close(release) })
+	server := startDNSHook(t, zone, func(name string) {
+		if name == "slow.test." {
+			<-release
+		}
+	})
+	cmd := exec.Command(os.Args[0], "--no-color", "--dns", server, "--timeout", "30s", "--format", "csv", "fast.test", "slow.test")
+	cmd.Env = append(os.Environ(), "DNXTY_TEST_MAIN=1")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	// The header and fast.test's row must arrive while slow.test's lookup
+	// is still held by the server.
+	for _, want := range []string{"Domain,TXT Record,Key,Value", "fast.test,key=fast,key,fast"} {
+		select {
+		case line := <-lines:
+			if line != want {
+				t.Fatalf("got line %q, want %q", line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q was not written while slow.test was still being looked up", want)
+		}
+	}
+	once.Do(func() { close(release) })
+	if line := <-lines; line != "slow.test,key=slow,key,slow" {
+		t.Errorf("got last line %q, want slow.test's row", line)
+	}
+	for range lines {
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("dnxty: %v", err)
+	}
+}
+
+func TestTableFormatsShareColumns(t *testing.T) {
+	zone := `
+example.test. 300 IN TXT "key=" "value"
+example.test. 300 IN TXT "key=" "other"
+`
+	flags := []string{"--max-txt-per-query", "1", "--compress-values", "--chunk-info", "--detect-wildcards", "--detect-parked", "--show-value-type", "--show-timing", "--tag", "run1"}
+	columns := []string{"Domain", "TXT Record", "Key", "Value", "Value Type", "Raw", "Chunks", "Wildcard", "Parked", "Parked With", "Lookup ms", "Tag"}
+	for _, format := range []string{"pretty", "csv", "org", "markdown"} {
+		args := append([]string{"--format", format}, flags...)
+		got, _ := runDnxty(t, zone, append(args, "example.test")...)
+		header := strings.ToLower(strings.SplitN(strings.TrimLeft(got, "+-\n"), "\n", 2)[0])
+		last := -1
+		for _, col := range columns {
+			i := strings.Index(header, strings.ToLower(col))
+			if i <= last {
+				t.Errorf("%s: column %q missing or out of order in header %q", format, col, header)
+				continue
+			}
+			last = i
+		}
+		for _, cell := range []string{"(truncated from", "text", "2", "false", "run1"} {
+			if !strings.Contains(got, cell) {
+				t.Errorf("%s: no %q cell in:\n%s", format, cell, got)
+			}
+		}
+	}
+}
+
+func TestProfilesWrittenOnFatalError(t *testing.T) {
+	dir := t.TempDir()
+	cpu, mem := dir+"/cpu.pprof", dir+"/mem.pprof"
+	cmd := exec.Command(os.Args[0], "--no-color", "--cpuprofile", cpu, "--memprofile", mem, "--socks5", "no-port", "example.test")
+	cmd.Env = append(os.Environ(), "DNXTY_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		t.Fatalf("want exit status 1, got %v\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Invalid --socks5 address") {
+		t.Errorf("fatal error not printed:\n%s", out)
+	}
+	for _, path := range []string{cpu, mem} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Finished profiles are gzip-compressed protobufs.
+		if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			t.Errorf("%s was not finalized (%d bytes)", path, len(data))
+		}
+	}
+}
+
+func TestJSONShapeMapAppliesToNDJSON(t *testing.T) {
+	zone := `
+a.test. 300 IN TXT "ms=one"
+a.test. 300 IN TXT "ms=two"
+a.test. 300 IN TXT "google=g1"
+b.test. 300 IN TXT "google=g2"
+c.test. 300 IN TXT "no pair here"
+`
+	got, _ := runDnxty(t, zone, "--format", "ndjson", "--json-shape", "map", "a.test", "b.test", "c.test")
+	want := `{"a.test":{"google":"g1","ms":["one","two"]}}
+{"b.test":{"google":"g2"}}
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSanitizeDomain(t *testing.T) {
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "Example.COM"},
+		{"https://user@example.com:443/path?q#f", "example.com"},
+		{"example.com:8080", "example.com"},
+		{"postmaster@example.com", "example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"https://Bücher.example/", "xn--bcher-kva.example"},
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"*.example.com", "*.example.com"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"", ""},
+		{"*.", ""},
+		{"*", ""},
+		{"a.*.example.com", ""},
+		{"**.example.com", ""},
+		{"exa mple.com", ""},
+		{"-example.com", ""},
+		{"example..com", ""},
+		{strings.Repeat("a", 64) + ".com", ""},
+	} {
+		got, err := sanitizeDomain(tc.input)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("sanitizeDomain(%q) = %q, want an error", tc.input, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("sanitizeDomain(%q) = %q, %v; want %q", tc.input, got, err, tc.want)
+		}
+	}
+}
+
+func TestIsSPF(t *testing.T) {
+	for txt, want := range map[string]bool{
+		"v=spf1 -all":        true,
+		"V=SPF1 include:x.y": true,
+		"v=spf1":             true,
+		"v=spf10 -all":       false,
+		"v=spf1-all":         false,
+		"v=spf":              false,
+		" v=spf1 -all":       false,
+		"v=DMARC1; p=none":   false,
+		"":                   false,
+	} {
+		if got := isSPF(txt); got != want {
+			t.Errorf("isSPF(%q) = %v, want %v", txt, got, want)
+		}
+	}
+}
+
+func TestLintSPFRecord(t *testing.T) {
+	for _, tc := range []struct {
+		record string
+		want   []string
+	}{
+		{"v=spf1 -all", nil},
+		{"v=spf1 include:_spf.example.com ip4:192.0.2.0/24 ip6:2001:db8::/32 mx a:mail.example.com/24 ~all", nil},
+		{"v=spf1 redirect=_spf.example.com", nil},
+		{"v=spf1 +all", []string{"error +all"}},
+		{"v=spf1 all", []string{"error all"}},
+		{"v=spf1 ?all", []string{"warning ?all"}},
+		{"v=spf1 ip4:192.0.2.1", []string{"warning "}},
+		{"v=spf1 -all ip4:192.0.2.1", []string{"warning ip4:192.0.2.1"}},
+		{"v=spf1 redirect=a.example redirect=b.example", []string{"error redirect=b.example"}},
+		{"v=spf1 redirect=example.com -all", []string{"info "}},
+		{"v=spf1 ptr -all", []string{"warning ptr"}},
+		{"v=spf1 ip4:2001:db8::1 -all", []string{"error ip4:2001:db8::1"}},
+		{"v=spf1 ip4:192.0.2.0/33 -all", []string{"error ip4:192.0.2.0/33"}},
+		{"v=spf1 include -all", []string{"error include"}},
+		{"v=spf1 ++mx -all", []string{"error ++mx"}},
+		{"v=spf1 foo -all", []string{"error foo"}},
+	} {
+		var got []string
+		lintSPFRecord(tc.record, func(severity, term, format string, args ...any) {
+			got = append(got, severity+" "+term)
+		})
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("lintSPFRecord(%q) = %q, want %q", tc.record, got, tc.want)
+		}
+	}
+}
+
+func TestSkipExistingSurvivesInterruptedResume(t *testing.T) {
+	zone := `
+a.test. 300 IN TXT "site=a1"
+b.test. 300 IN TXT "site=b1"
+`
+	for _, tc := range []struct {
+		file string
+		want []string // in the file after every run
+	}{
+		{"results.json.gz", []string{`"domain": "a.test"`, `"tag": "first"`, `"lookup_ms"`}},
+		{"results.csv", []string{"Domain,TXT Record,Key,Value,Lookup ms,Tag", "a.test,site=a1,site,a1,", ",first"}},
+	} {
+		dir := t.TempDir()
+		path := dir + "/" + tc.file
+		read := func() string {
+			t.Helper()
+			out, err := exec.Command("sh", "-c", "gzip -dcf "+path).Output()
+			if err != nil {
+				t.Fatalf("%s: reading output: %v", tc.file, err)
+			}
+			return string(out)
+		}
+		runDnxty(t, zone, "--output", path, "--tag", "first", "--show-timing", "a.test")
+		first := read()
+
+		// Resume with b.test hanging, and interrupt the run while it waits.
+		queried, release := make(chan bool, 1), make(chan bool)
+		server := startDNSHook(t, zone, func(name string) {
+			if name == "b.test." {
+				queried <- true
+				<-release
+			}
+		})
+		t.Cleanup(func() { close(release) })
+		cmd := exec.Command(os.Args[0], "--no-color", "--dns", server, "--output", path, "--skip-existing", "--tag", "second", "--show-timing", "a.test", "b.test")
+		cmd.Env = append(os.Environ(), "DNXTY_TEST_MAIN=1")
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-queried:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			t.Fatalf("%s: b.test was never queried", tc.file)
+		}
+		cmd.Process.Signal(os.Interrupt)
+		cmd.Wait()
+		got := read()
+		for _, want := range tc.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: %q lost after an interrupted resume:\n%s", tc.file, want, got)
+			}
+		}
+		if strings.HasSuffix(tc.file, ".gz") && got != first {
+			t.Errorf("%s changed after an interrupted resume:\n%s\nwas:\n%s", tc.file, got, first)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("%s: temporary files left behind: %v", tc.file, entries)
+		}
+
+		// A completed resume keeps the earlier results with every column.
+		runDnxty(t, zone, "--output", path, "--skip-existing", "--tag", "second", "--show-timing", "a.test", "b.test")
+		got = read()
+		for _, want := range append(tc.want, "b1", "second") {
+			if !strings.Contains(got, want) {
+				t.Errorf("%s: no %q after a completed resume:\n%s", tc.file, want, got)
+			}
+		}
+	}
+}
+
+func TestDedupeValuesGroupsUnredacted(t *testing.T) {
+	zone := `
+a.test. 300 IN TXT "site=AAAASECRETAAAABBBB"
+b.test. 300 IN TXT "site=AAAAXXXXXXXXXXBBBB"
+c.test. 300 IN TXT "site=AAAASHAREDVALUEBBBB"
+d.test. 300 IN TXT "other=AAAASHAREDVALUEBBBB"
+`
+	domains := []string{"a.test", "b.test", "c.test", "d.test"}
+	for _, flags := range [][]string{nil, {"--redact"}} {
+		args := append([]string{"--dedupe-values-global", "--format", "csv"}, flags...)
+		got, _ := runDnxty(t, zone, append(args, domains...)...)
+		want := "Value,Count,Domains\nAAAASHAREDVALUEBBBB,2,\"c.test, d.test\"\n"
+		if flags != nil {
+			want = "Value,Count,Domains\nAAAA***********BBBB,2,\"c.test, d.test\"\n"
+		}
+		if got != want {
+			t.Errorf("%v: got:\n%s\nwant:\n%s", flags, got, want)
+		}
+	}
+}
+
+func TestSharedTokensGroupsUnredacted(t *testing.T) {
+	zone := `
+a.test. 300 IN TXT "google-site-verification=AAAASECRETAAAABBBB"
+b.test. 300 IN TXT "google-site-verification=AAAAXXXXXXXXXXBBBB"
+`
+	for _, flags := range [][]string{nil, {"--redact"}} {
+		args := append([]string{"--find-shared-tokens", "--format", "csv"}, flags...)
+		if got, _ := runDnxty(t, zone, append(args, "a.test", "b.test")...); got != "Key,Token,Count,Domains\n" {
+			t.Errorf("%v: distinct tokens reported as shared:\n%s", flags, got)
+		}
+	}
+	zone += `c.test. 300 IN TXT "google-site-verification=AAAASECRETAAAABBBB"` + "\n"
+	got, _ := runDnxty(t, zone, "--find-shared-tokens", "--format", "csv", "--redact", "a.test", "b.test", "c.test")
+	if want := "Key,Token,Count,Domains\ngoogle-site-verification,AAAA**********BBBB,2,\"a.test, c.test\"\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseRowRoundTrip(t *testing.T) {
+	defer func(vt, ci, dw, dp, cn, sa, st, sr bool, tag string, pool *resolverPool) {
+		showValueType, chunkInfo, detectWildcards, detectParked, includeCNAME, showAuthority, showTiming, showRaw, runTag, resolvers = vt, ci, dw, dp, cn, sa, st, sr, tag, pool
+	}(showValueType, chunkInfo, detectWildcards, detectParked, includeCNAME, showAuthority, showTiming, showRaw, runTag, resolvers)
+	showValueType, chunkInfo, detectWildcards, detectParked, includeCNAME, showAuthority, showTiming, showRaw, runTag = true, true, true, true, true, true, true, true, "run1"
+	resolvers = &resolverPool{strategy: "all", servers: []string{"192.0.2.1:53", "192.0.2.2:53"}}
+
+	metaCols := []string{"owner", "team"}
+	for _, r := range []DomainTXT{
+		{Domain: "example.test", TXT: "k=v", Key: "k", Value: "v", ValueType: "text", Tag: "run1"},
+		{Domain: "a.test", TXT: "free text", ValueType: "text", Chunks: 3, Wildcard: true, Parked: true, ParkedWith: "parkingcrew",
+			CNAMEChain: []string{"a.test", "b.test"}, Authority: []string{"ns1.test", "ns2.test"}, LookupMs: 12.5,
+			TruncatedFrom: 1500, Tag: "run1", Meta: map[string]string{"owner": "ops"}},
+		{Domain: "spf.test", TXT: "v=spf1 -all", Raw: "v=spf1   -all", ValueType: "text", Disagreement: true, Tag: "run1"},
+	} {
+		got, err := parseRow(fullHeader(metaCols), r.row(metaCols))
+		if err != nil {
+			t.Fatalf("parseRow(%v): %v", r, err)
+		}
+		if !reflect.DeepEqual(got, r) {
+			t.Errorf("parseRow(row(%+v)) = %+v", r, got)
+		}
+	}
+	if _, err := parseRow([]string{"Domain", "Chunks"}, []string{"a.test", "many"}); err == nil {
+		t.Error("parseRow accepted a non-numeric Chunks cell")
+	}
+}
+
+func TestDisagreementColumn(t *testing.T) {
+	one := startDNS(t, `a.test. 300 IN TXT "k=one"`)
+	two := startDNS(t, `a.test. 300 IN TXT "k=two"`)
+	got, _ := runCLI(t, "--resolvers", one+","+two, "--resolver-strategy", "all", "--format", "csv", "a.test")
+	if want := "Domain,TXT Record,Key,Value,Disagreement\na.test,k=one,k,one,true\n"; got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFetchDomainListTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // never respond
+	}))
+	defer server.Close()
+	defer func(d time.Duration) { listTimeout = d }(listTimeout)
+	listTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := fetchDomainList(server.URL); err == nil {
+		t.Fatal("fetchDomainList returned no error for a server that never responds")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("fetchDomainList took %v with a 100ms --list-timeout", elapsed)
+	}
+}
+
+func TestFetchDomainListDefaultTimeout(t *testing.T) {
+	if listTimeout <= 0 {
+		t.Errorf("--list-timeout defaults to %v; a stalled server would hang the run", listTimeout)
+	}
+}
+
+// countingDNS is startDNS counting the queries the server receives.
+func countingDNS(t *testing.T, zone string, delay time.Duration) (addr string, queries func() int) {
+	t.Helper()
+	var mu sync.Mutex
+	n := 0
+	addr = startDNSHook(t, zone, func(string) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		time.Sleep(delay)
+	})
+	return addr, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+// deadDNS returns a local UDP address nothing listens on, so queries to it
+// fail at once with connection refused.
+func deadDNS(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	return addr
+}
+
+// usePool makes pool the resolver pool of in-process lookups for the rest
+// of the test.
+func usePool(t *testing.T, pool *resolverPool) {
+	t.Helper()
+	saved := resolvers
+	resolvers = pool
+	t.Cleanup(func() { resolvers = saved })
+}
+
+func TestResolverStrategies(t *testing.T) {
+	one, oneQueries := countingDNS(t, `
+a.test. 300 IN TXT "k=one"
+same.test. 300 IN TXT "k=same"
+`, 0)
+	two, twoQueries := countingDNS(t, `
+a.test. 300 IN TXT "k=two"
+same.test. 300 IN TXT "k=same"
+`, 0)
+	slow, _ := countingDNS(t, `a.test. 300 IN TXT "k=slow"`, 300*time.Millisecond)
+	dead := deadDNS(t)
+
+	for _, tc := range []struct {
+		strategy string
+		servers  []string
+		lookups  int
+		want     string // the answer for a.test
+		queries  [2]int // received by one and two
+	}{
+		{"round-robin", []string{one, two}, 4, "", [2]int{2, 2}},
+		{"first", []string{one, two}, 2, "k=one", [2]int{2, 0}},
+		{"first", []string{dead, two}, 2, "k=two", [2]int{0, 2}},
+		{"fastest", []string{slow, two}, 1, "k=two", [2]int{0, 1}},
+		{"all", []string{two, one}, 1, "k=two", [2]int{1, 1}},
+		{"all", []string{dead, one}, 1, "k=one", [2]int{1, 0}},
+	} {
+		name := fmt.Sprintf("%s %v", tc.strategy, tc.servers)
+		usePool(t, &resolverPool{strategy: tc.strategy, servers: tc.servers})
+		before := [2]int{oneQueries(), twoQueries()}
+		for range tc.lookups {
+			txts, err := lookupTXTRecords("a.test")
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if tc.want != "" && !slices.Equal(txts, []string{tc.want}) {
+				t.Errorf("%s: answer %q, want %q", name, txts, tc.want)
+			}
+		}
+		if got := [2]int{oneQueries() - before[0], twoQueries() - before[1]}; got != tc.queries {
+			t.Errorf("%s: servers received %v queries, want %v", name, got, tc.queries)
+		}
+	}
+}
+
+func TestResolverDisagreements(t *testing.T) {
+	one := startDNS(t, `
+a.test. 300 IN TXT "k=one"
+same.test. 300 IN TXT "k=same"
+`)
+	two := startDNS(t, `
+a.test. 300 IN TXT "k=two"
+same.test. 300 IN TXT "k=same"
+`)
+	dead := deadDNS(t)
+	pool := &resolverPool{strategy: "all", servers: []string{one, two, dead}}
+	usePool(t, pool)
+	for _, name := range []string{"a.test", "same.test", "missing.test"} {
+		lookupTXTRecords(name)
+	}
+	want := []ResolverAnswer{
+		{Resolver: one, Records: []string{"k=one"}},
+		{Resolver: two, Records: []string{"k=two"}},
+	}
+	if got := pool.disagreement("TXT", "a.test"); !reflect.DeepEqual(got, want) {
+		t.Errorf("disagreement on a.test = %+v, want %+v", got, want)
+	}
+	for _, name := range []string{"same.test", "missing.test"} {
+		if got := pool.disagreement("TXT", name); got != nil {
+			t.Errorf("disagreement recorded for %s, where the servers that answered agree: %+v", name, got)
+		}
+	}
+
+	// A server answering "not found" disagrees with one returning records.
+	partial := startDNS(t, `same.test. 300 IN TXT "k=same"`)
+	pool = &resolverPool{strategy: "all", servers: []string{one, partial}}
+	usePool(t, pool)
+	lookupTXTRecords("a.test")
+	want = []ResolverAnswer{
+		{Resolver: one, Records: []string{"k=one"}},
+		{Resolver: partial, NotFound: true},
+	}
+	if got := pool.disagreement("TXT", "a.test"); !reflect.DeepEqual(got, want) {
+		t.Errorf("disagreement with a not-found answer = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolverRotateOnError(t *testing.T) {
+	live, queries := countingDNS(t, `a.test. 300 IN TXT "k=v"`, 0)
+	dead := deadDNS(t)
+	pool := &resolverPool{
+		strategy:    "round-robin",
+		servers:     []string{dead, live},
+		rotateAfter: 2,
+		cooldown:    200 * time.Millisecond,
+		streaks:     make([]int, 2),
+		droppedAt:   make([]time.Time, 2),
+	}
+	usePool(t, pool)
+	lookup := func() error {
+		_, err := lookupTXTRecords("a.test")
+		return err
+	}
+
+	// Round-robin alternates until the dead server has failed twice.
+	var failures int
+	for range 4 {
+		if lookup() != nil {
+			failures++
+		}
+	}
+	if failures != 2 || !slices.Equal(pool.dropped, []string{dead}) {
+		t.Fatalf("after 4 lookups: %d failures, dropped %v; want 2 and [%s]", failures, pool.dropped, dead)
+	}
+	// Once dropped, every lookup goes to the live server.
+	before := queries()
+	for range 4 {
+		if err := lookup(); err != nil {
+			t.Errorf("lookup after rotation: %v", err)
+		}
+	}
+	if got := queries() - before; got != 4 {
+		t.Errorf("live server received %d of 4 queries after rotation", got)
+	}
+	// After the cooldown the dead server is used again.
+	time.Sleep(250 * time.Millisecond)
+	failures = 0
+	for range 2 {
+		if lookup() != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("%d of 2 lookups failed after the cooldown, want 1 (the re-admitted server)", failures)
+	}
+
+	// The last server in use is never dropped.
+	pool = &resolverPool{strategy: "round-robin", servers: []string{dead, deadDNS(t)}, rotateAfter: 1,
+		streaks: make([]int, 2), droppedAt: make([]time.Time, 2)}
+	usePool(t, pool)
+	for range 4 {
+		lookup()
+	}
+	if len(pool.dropped) != 1 {
+		t.Errorf("dropped %v; the last server in use must stay", pool.dropped)
+	}
+}
+
+func TestClassifyValue(t *testing.T) {
+	for value, want := range map[string]string{
+		"":                                     "",
+		"192.0.2.1":                            "ip",
+		"2001:db8::1":                          "ip",
+		"192.0.2.256":                          "text",
+		"dmarc@example.com":                    "email",
+		"a@b":                                  "text",
+		"https://example.com/path?q=1":         "url",
+		"ftp://files.example.com":              "url",
+		"42":                                   "numeric",
+		"-3.14":                                "numeric",
+		"1.":                                   "text",
+		"dGhpcyBpcyBhIGxvbmcgYmFzZTY0IGJsb2I=": "base64",
+		strings.Repeat("A", 32):                "base64",
+		"abc123def456":                         "token",
+		"1234567890ab":                         "token",
+		"abcdefghij":                           "text",
+		"abc12":                                "text",
+		"two words 123":                        "text",
+		strings.Repeat("x1", 5000):             "base64",
+		"ключ12345678":                         "text",
+	} {
+		if got := classifyValue(value); got != want {
+			t.Errorf("classifyValue(%.40q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestReadNDJSONRows(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+	for _, tc := range []struct {
+		name    string
+		data    string
+		strict  bool
+		domains []string
+		metas   []map[string]string
+		err     string
+	}{
+		{name: "empty", data: ""},
+		{name: "lines with metadata",
+			data:    "{\"domain\": \"a.test\", \"owner\": \"ops\", \"rank\": 3}\r\n\n{\"domain\": \" b.test \"}\n",
+			domains: []string{"a.test", "b.test"},
+			metas:   []map[string]string{{"owner": "ops", "rank": "3"}, {}}},
+		{name: "array",
+			data:    `[{"domain": "a.test", "ok": true}, {"domain": "b.test"}]`,
+			domains: []string{"a.test", "b.test"},
+			metas:   []map[string]string{{"ok": "true"}, {}}},
+		{name: "malformed lines skipped",
+			data:    "{\"domain\": \"a.test\"}\n{not json\n42\nnull\n{\"name\": \"x\"}\n{\"domain\": 5}\n{\"domain\": \"\"}\n{\"domain\": \"b.test\"}\n",
+			domains: []string{"a.test", "b.test"},
+			metas:   []map[string]string{{}, {}}},
+		{name: "strict invalid JSON", data: "{\"domain\": \"a.test\"}\n{not json\n", strict: true, err: "line 2: invalid JSON"},
+		{name: "strict not an object", data: "42\n", strict: true, err: "line 1: not a JSON object"},
+		{name: "strict missing domain", data: "\n{\"name\": \"x\"}\n", strict: true, err: `line 2: missing "domain" field`},
+		{name: "malformed array", data: `[{"domain": "a.test"}`, err: "unexpected end of JSON input"},
+		{name: "oversized value",
+			data:    `{"domain": "a.test", "note": "` + long + `"}`,
+			domains: []string{"a.test"},
+			metas:   []map[string]string{{"note": long}}},
+	} {
+		domains, metas, err := readNDJSONRows([]byte(tc.data), tc.strict)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(domains, tc.domains) || !reflect.DeepEqual(metas, tc.metas) {
+			t.Errorf("%s: got %q, %.80v, %v; want %q, %.80v", tc.name, domains, metas, err, tc.domains, tc.metas)
+		}
+	}
+}
+
+func TestCountSPFLookups(t *testing.T) {
+	zone := `
+simple.test.   300 IN TXT "v=spf1 ip4:192.0.2.0/24 -all"
+mix.test.      300 IN TXT "v=spf1 a MX ?ptr exists:%{i}.x.test include:inc.test ip4:192.0.2.1 ~all"
+inc.test.      300 IN TXT "v=spf1 include:inc2.test -all"
+inc2.test.     300 IN TXT "v=spf1 +a:foo.test/24 -all"
+redirect.test. 300 IN TXT "v=spf1 redirect=mix.test"
+loop.test.     300 IN TXT "v=spf1 include:loop.test -all"
+broken.test.   300 IN TXT "v=spf1 include:missing.test -all"
+nospf.test.    300 IN TXT "hello"
+`
+	over := "v=spf1"
+	for range 11 {
+		over += " include:simple.test"
+	}
+	zone += `over.test. 300 IN TXT "` + over + ` -all"` + "\n"
+	useDNS(t, zone)
+	for _, tc := range []struct {
+		domain    string
+		lookups   int
+		exceeded  bool
+		breakdown map[string]int
+		err       bool
+	}{
+		{domain: "simple.test", breakdown: map[string]int{}},
+		{domain: "mix.test", lookups: 7, breakdown: map[string]int{"a": 2, "mx": 1, "ptr": 1, "exists": 1, "include": 2}},
+		{domain: "redirect.test", lookups: 8, breakdown: map[string]int{"redirect": 1, "a": 2, "mx": 1, "ptr": 1, "exists": 1, "include": 2}},
+		{domain: "loop.test", lookups: 1, breakdown: map[string]int{"include": 1}},
+		{domain: "broken.test", lookups: 1, breakdown: map[string]int{"include": 1}},
+		{domain: "over.test", lookups: 11, exceeded: true, breakdown: map[string]int{"include": 11}},
+		{domain: "nospf.test", err: true},
+		{domain: "missing.test", err: true},
+	} {
+		got := countSPFLookups(tc.domain)
+		if tc.err {
+			if got.Error == "" {
+				t.Errorf("%s: no error, got %+v", tc.domain, got)
+			}
+			continue
+		}
+		if got.Error != "" || got.Lookups != tc.lookups || got.Exceeded != tc.exceeded || !maps.Equal(got.Breakdown, tc.breakdown) {
+			t.Errorf("%s: got %+v, want %d lookups (exceeded %v), %v", tc.domain, got, tc.lookups, tc.exceeded, tc.breakdown)
+		}
+	}
+}
+
+func TestExplainSPFLimitFlagsDomainsOverTheLimit(t *testing.T) {
+	over := "v=spf1"
+	for range 11 {
+		over += " a:a.test"
+	}
+	zone := `
+ok.test.   300 IN TXT "v=spf1 mx -all"
+over.test. 300 IN TXT "` + over + ` -all"
+`
+	got, stderr := runDnxty(t, zone, "--explain-spf-limit", "--format", "json", "ok.test", "over.test")
+	for _, want := range []string{`"domain": "ok.test"`, `"lookups": 1`, `"lookups": 11`, `"exceeded": true`} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %s in:\n%s", want, got)
+		}
+	}
+	if !strings.Contains(stderr, "over.test") || strings.Contains(stderr, "ok.test") {
+		t.Errorf("want a warning for over.test only, got:\n%s", stderr)
+	}
+}
+
+func TestSummarizeSPF(t *testing.T) {
+	useDNS(t, `
+strict.test.  300 IN TXT "v=spf1 include:a.test include:b.test -all"
+upper.test.   300 IN TXT "V=SPF1 MX ~ALL"
+bare.test.    300 IN TXT "v=spf1 all"
+noall.test.   300 IN TXT "v=spf1 ip4:192.0.2.1"
+qual.test.    300 IN TXT "v=spf1 +include:x.test ?all"
+empty.test.   300 IN TXT "v=spf1"
+nospf.test.   300 IN TXT "hello"
+spf10.test.   300 IN TXT "v=spf10 -all"
+`)
+	for _, tc := range []struct {
+		domain string
+		want   SPFSummary
+		err    bool
+	}{
+		{domain: "strict.test", want: SPFSummary{HasSPF: true, All: "-all", Includes: 2}},
+		{domain: "upper.test", want: SPFSummary{HasSPF: true, All: "~all"}},
+		{domain: "bare.test", want: SPFSummary{HasSPF: true, All: "+all"}},
+		{domain: "noall.test", want: SPFSummary{HasSPF: true}},
+		{domain: "qual.test", want: SPFSummary{HasSPF: true, All: "?all", Includes: 1}},
+		{domain: "empty.test", want: SPFSummary{HasSPF: true}},
+		{domain: "nospf.test"},
+		{domain: "spf10.test"},
+		{domain: "missing.test", err: true},
+	} {
+		got := summarizeSPF(tc.domain)
+		if tc.err != (got.Error != "") {
+			t.Errorf("%s: error %q, want error %v", tc.domain, got.Error, tc.err)
+			continue
+		}
+		got.Error = ""
+		tc.want.Domain = tc.domain
+		if got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.domain, got, tc.want)
+		}
+	}
+}
+
+func TestRecordReferences(t *testing.T) {
+	for _, tc := range []struct {
+		txt  string
+		want []string
+	}{
+		{"", nil},
+		{"v=spf1 -all", nil},
+		{"v=spf1 include:_spf.Google.com ~include:b.test redirect=C.test a:ignored.test -all", []string{"_spf.google.com", "b.test", "c.test"}},
+		{"V=SPF1 +include:x.test", []string{"x.test"}},
+		{"v=spf10 include:x.test", nil},
+		{"v=DMARC1; p=none; rua=mailto:a@Reports.test,mailto:b@other.test!10m; ruf=mailto:c@forensic.test", []string{"reports.test", "other.test", "forensic.test"}},
+		{"v=DMARC1; p=reject; rua=https://reports.test", nil},
+		{"google-site-verification=include:x.test", nil},
+	} {
+		if got := recordReferences(tc.txt); !slices.Equal(got, tc.want) {
+			t.Errorf("recordReferences(%q) = %q, want %q", tc.txt, got, tc.want)
+		}
+	}
+}
+
+func TestExpandReferences(t *testing.T) {
+	useDNS(t, `
+_spf.mail.test. 300 IN TXT "v=spf1 include:relay.test redirect=example.test"
+_spf.mail.test. 300 IN A   192.0.2.10
+relay.test.     300 IN TXT "v=spf1 include:deep.test -all"
+relay.test.     300 IN A   192.0.2.20
+relay.test.     300 IN MX  10 mx.relay.test.
+example.test.   300 IN A   192.0.2.30
+`)
+	spf := "v=spf1 include:_spf.mail.test -all"
+	dmarc := "v=DMARC1; p=none; rua=mailto:d@missing.test,mailto:x@relay.test"
+	// describe renders a reference tree as "domain[A|MX|error](children)".
+	var describe func(refs []*Reference) string
+	describe = func(refs []*Reference) string {
+		var parts []string
+		for _, ref := range refs {
+			s := fmt.Sprintf("%s[%s|%s|%v]", ref.Domain, strings.Join(ref.A, ","), strings.Join(ref.MX, ","), ref.Error != "")
+			if len(ref.References) > 0 {
+				s += "(" + describe(ref.References) + ")"
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, " ")
+	}
+	for _, tc := range []struct {
+		depth int
+		want  []string // per result
+	}{
+		{1, []string{
+			"_spf.mail.test[192.0.2.10||false]",
+			"missing.test[||true] relay.test[192.0.2.20|mx.relay.test|false]",
+			"",
+			"_spf.mail.test[192.0.2.10||false]",
+		}},
+		// relay.test is referenced again at level 2 but resolved once, and
+		// example.test's own records are not followed past the last level.
+		{2, []string{
+			"_spf.mail.test[192.0.2.10||false](example.test[192.0.2.30||false])",
+			"missing.test[||true] relay.test[192.0.2.20|mx.relay.test|false](deep.test[||true])",
+			"",
+			"_spf.mail.test[192.0.2.10||false](example.test[192.0.2.30||false])",
+		}},
+	} {
+		results := []DomainTXT{
+			{Domain: "example.test", TXT: spf},
+			{Domain: "_dmarc.example.test", TXT: dmarc},
+			{Domain: "example.test", TXT: "free text"},
+			{Domain: "example.test", TXT: spf, Key: "duplicate"},
+		}
+		expandReferences(results, tc.depth, 2)
+		for i, r := range results {
+			if got := describe(r.References); got != tc.want[i] {
+				t.Errorf("depth %d, result %d: references %s, want %s", tc.depth, i, got, tc.want[i])
+			}
+		}
+	}
+}
+
+func TestDomainListed(t *testing.T) {
+	list := map[string]bool{"example.com": true, "co.uk": true, "_dmarc.other.test": true}
+	for _, tc := range []struct {
+		domain     string
+		subdomains bool
+		want       bool
+	}{
+		{"example.com", false, true},
+		{"example.com.", false, true},
+		{"www.example.com", false, false},
+		{"www.example.com", true, true},
+		{"a.b.example.com.", true, true},
+		{"notexample.com", true, false},
+		{"example.com.evil.test", true, false},
+		{"shop.co.uk", true, true},
+		{"_dmarc.other.test", false, true},
+		{"other.test", true, false},
+		{"com", true, false},
+		{"", true, false},
+		{".", true, false},
+		{strings.Repeat("a.", 200) + "example.com", true, true},
+	} {
+		if got := domainListed(list, tc.domain, tc.subdomains); got != tc.want {
+			t.Errorf("domainListed(%.40q, subdomains=%v) = %v, want %v", tc.domain, tc.subdomains, got, tc.want)
+		}
+	}
+	if domainListed(nil, "example.com", true) {
+		t.Error("domainListed matched an empty list")
+	}
+}
+
+func TestCNAMEChain(t *testing.T) {
+	rrs := func(lines ...string) []dns.RR {
+		var answer []dns.RR
+		for _, line := range lines {
+			rr, err := dns.NewRR(line)
+			if err != nil {
+				t.Fatalf("parsing %q: %v", line, err)
+			}
+			answer = append(answer, rr)
+		}
+		return answer
+	}
+	for _, tc := range []struct {
+		name   string
+		answer []dns.RR
+		want   []string
+	}{
+		{"www.example.test", nil, nil},
+		{"www.example.test", rrs(`www.example.test. 300 IN TXT "k=v"`), nil},
+		{"www.example.test", rrs(
+			"www.example.test. 300 IN CNAME edge.cdn.test.",
+			`edge.cdn.test. 300 IN TXT "k=v"`,
+		), []string{"www.example.test", "edge.cdn.test"}},
+		{"WWW.Example.test", rrs(
+			"www.example.test. 300 IN CNAME A.cdn.test.",
+			"a.cdn.test. 300 IN CNAME b.cdn.test.",
+			"other.test. 300 IN CNAME unrelated.test.",
+		), []string{"WWW.Example.test", "A.cdn.test", "b.cdn.test"}},
+		{"www.example.test", rrs(
+			"unrelated.test. 300 IN CNAME other.test.",
+		), nil},
+		{"loop.test", rrs(
+			"loop.test. 300 IN CNAME back.test.",
+			"back.test. 300 IN CNAME loop.test.",
+		), []string{"loop.test", "back.test", "loop.test"}},
+	} {
+		if got := cnameChain(tc.name, tc.answer); !slices.Equal(got, tc.want) {
+			t.Errorf("cnameChain(%q, %v) = %q, want %q", tc.name, tc.answer, got, tc.want)
+		}
+	}
+}
+
+func TestZoneTXT(t *testing.T) {
+	long := strings.Repeat("a", 600)
+	for _, tc := range []struct {
+		txt, want string
+	}{
+		{"", `""`},
+		{"v=spf1 -all", `"v=spf1 -all"`},
+		{`say "hi" \o/`, `"say \"hi\" \\o/"`},
+		{"tab\there\x7fé", `"tab\009here\127\195\169"`},
+		{strings.Repeat("x", 255), `"` + strings.Repeat("x", 255) + `"`},
+		{strings.Repeat("x", 256), `"` + strings.Repeat("x", 255) + `" "x"`},
+		{long, `"` + long[:255] + `" "` + long[255:510] + `" "` + long[510:] + `"`},
+	} {
+		got := zoneTXT(tc.txt)
+		if got != tc.want {
+			t.Errorf("zoneTXT(%q) = %q, want %q", tc.txt, got, tc.want)
+			continue
+		}
+		// The output must load back as the same record.
+		rr, err := dns.NewRR("example.test. 300 IN TXT " + got)
+		if err != nil {
+			t.Errorf("parsing zoneTXT(%q): %v", tc.txt, err)
+			continue
+		}
+		if joined := strings.Join(rr.(*dns.TXT).Txt, ""); unescapeCharString(joined) != tc.txt {
+			t.Errorf("zoneTXT(%q) loads back as %q", tc.txt, joined)
+		}
+	}
+}
+
+func TestTableCell(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"v=spf1 -all", "v=spf1 -all"},
+		{"line one\nline two", "line one\nline two"},
+		{"a\tb", "a       b"},
+		{"abcdefgh\tb", "abcdefgh        b"},
+		{"x\n\ty", "x\n        y"},
+		{"日本\tx", "日本    x"},
+		{"\x1b[31mred\x1b[0m", `\x1b[31mred\x1b[0m`},
+		{"\x1b\tz", `\x1b    z`},
+		{"cr\rlf", `cr\x0dlf`},
+		{"nul\x00", `nul\x00`},
+		{"c1\u0085", `c1\x85`},
+	} {
+		if got := tableCell(tc.in); got != tc.want {
+			t.Errorf("tableCell(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	row := func(domain, key, value string) DomainTXT {
+		return DomainTXT{Domain: domain, Key: key, Value: value, TXT: key + "=" + value}
+	}
+	for _, tc := range []struct {
+		name string
+		in   []DomainTXT
+		sep  string
+		want []DomainTXT
+	}{
+		{"empty", nil, ";", nil},
+		{"distinct", []DomainTXT{row("a.test", "k", "1"), row("a.test", "j", "2"), row("b.test", "k", "3")}, ";",
+			[]DomainTXT{row("a.test", "k", "1"), row("a.test", "j", "2"), row("b.test", "k", "3")}},
+		{"merged in order", []DomainTXT{row("a.test", "k", "1"), row("b.test", "k", "2"), row("a.test", "k", "3"), row("a.test", "k", "4")}, ", ",
+			[]DomainTXT{{Domain: "a.test", Key: "k", Value: "1, 3, 4", TXT: "k=1, k=3, k=4"}, row("b.test", "k", "2")}},
+		{"keyless rows kept", []DomainTXT{{Domain: "a.test", TXT: "free text"}, {Domain: "a.test", TXT: "free text"}, row("a.test", "k", "1")}, ";",
+			[]DomainTXT{{Domain: "a.test", TXT: "free text"}, {Domain: "a.test", TXT: "free text"}, row("a.test", "k", "1")}},
+		{"empty values", []DomainTXT{row("a.test", "k", ""), row("a.test", "k", "")}, "|",
+			[]DomainTXT{{Domain: "a.test", Key: "k", Value: "|", TXT: "k=|k="}}},
+		{"domain and key not confused", []DomainTXT{row("a.test", "b", "1"), row("a.testb", "", "2"), row("a.test", "b", "3")}, ";",
+			[]DomainTXT{{Domain: "a.test", Key: "b", Value: "1;3", TXT: "b=1;b=3"}, row("a.testb", "", "2")}},
+	} {
+		if got := mergeValues(tc.in, tc.sep); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: mergeValues = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// setSimplify sets --simplify-mode and --key-delimiter for the test.
+func setSimplify(t *testing.T, mode, delim string) {
+	oldMode, oldDelim := simplifyMode, keyDelimiter
+	simplifyMode, keyDelimiter = mode, delim
+	t.Cleanup(func() { simplifyMode, keyDelimiter = oldMode, oldDelim })
+}
+
+func TestSimplifyKeyModes(t *testing.T) {
+	for _, tc := range []struct {
+		mode, key, want string
+	}{
+		{"prefix", "google-site-verification", "google"},
+		{"suffix", "google-site-verification", "verification"},
+		{"full", "google-site-verification", "google-site-verification"},
+		{"prefix", "plain", "plain"},
+		{"suffix", "plain", "plain"},
+		{"prefix", "", ""},
+		{"suffix", "", ""},
+		{"prefix", "-leading", ""},
+		{"suffix", "trailing-", ""},
+		{"prefix", "a--b", "a"},
+		{"suffix", "a--b", "b"},
+	} {
+		setSimplify(t, tc.mode, "-")
+		if got := simplifyKey(tc.key); got != tc.want {
+			t.Errorf("simplifyKey(%q) with mode %s = %q, want %q", tc.key, tc.mode, got, tc.want)
+		}
+	}
+}
+
+func TestSimplifyKeyDelimiter(t *testing.T) {
+	for _, tc := range []struct {
+		mode, delim, key, want string
+	}{
+		{"prefix", "-", "google_site_verification", "google_site_verification"},
+		{"prefix", "_", "google_site_verification", "google"},
+		{"suffix", "_", "google_site_verification", "verification"},
+		{"prefix", ".", "ms.domain-verify", "ms"},
+		{"suffix", ".", "ms.domain-verify", "domain-verify"},
+		{"prefix", "::", "a::b::c", "a"},
+		{"suffix", "::", "a::b::c", "c"},
+		{"prefix", "::", "a:b", "a:b"},
+		{"full", "_", "google_site_verification", "google_site_verification"},
+	} {
+		setSimplify(t, tc.mode, tc.delim)
+		if got := simplifyKey(tc.key); got != tc.want {
+			t.Errorf("simplifyKey(%q) with mode %s and delimiter %q = %q, want %q", tc.key, tc.mode, tc.delim, got, tc.want)
+		}
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	timeoutOp := &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{&net.DNSError{Err: "no such host", Name: "x.test", IsNotFound: true}, "not-found"},
+		{fmt.Errorf("lookup: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), "not-found"},
+		{context.DeadlineExceeded, "timeout"},
+		{fmt.Errorf("query: %w", os.ErrDeadlineExceeded), "timeout"},
+		{timeoutOp, "timeout"},
+		{&net.OpError{Op: "dial", Net: "udp", Err: errors.New("connection refused")}, "network"},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, "timeout"},
+		{&net.DNSError{Err: "server misbehaving"}, "servfail"},
+		{&net.DNSError{Err: "SERVFAIL"}, "servfail"},
+		{&net.DNSError{Err: "REFUSED"}, "refused"},
+		{&net.DNSError{Err: "dial udp 127.0.0.1:53: connect: connection refused"}, "network"},
+		{&net.DNSError{Err: "read udp 127.0.0.1:53: connection reset"}, "network"},
+		{&net.DNSError{Err: "cannot unmarshal DNS message"}, "dns-error"},
+		{&net.DNSError{}, "dns-error"},
+		{errors.New("something else"), "network"},
+	} {
+		if got := errorCategory(tc.err); got != tc.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWildcardRecords(t *testing.T) {
+	zone := `
+*.example.test.   300 IN TXT "wild=everywhere"
+www.example.test. 300 IN TXT "site-verification=real"
+`
+	domains := []string{"www.example.test", "api.example.test"}
+	stdout, _ := runDnxty(t, zone, append([]string{"--format", "json", "--all", "--detect-wildcards"}, domains...)...)
+	var results []DomainTXT
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("parsing output: %v\n%s", err, stdout)
+	}
+	wildcard := make(map[string]bool)
+	for _, r := range results {
+		wildcard[r.Domain+" "+r.TXT] = r.Wildcard
+	}
+	want := map[string]bool{
+		"www.example.test site-verification=real": false,
+		"api.example.test wild=everywhere":        true,
+	}
+	if !maps.Equal(wildcard, want) {
+		t.Errorf("--detect-wildcards: got %v, want %v", wildcard, want)
+	}
+
+	stdout, _ = runDnxty(t, zone, append([]string{"--format", "json", "--all", "--no-wildcard"}, domains...)...)
+	if !strings.Contains(stdout, "site-verification=real") || strings.Contains(stdout, "wild=everywhere") {
+		t.Errorf("--no-wildcard kept the wildcard record or dropped the real one:\n%s", stdout)
+	}
+}
+
+// servfailDNS starts a DNS server answering every query with SERVFAIL and
+// returns its address and a count of the queries it has received.
+func servfailDNS(t *testing.T) (addr string, queries func() int) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	var mu sync.Mutex
+	n := 0
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		n++
+		mu.Unlock()
+		m := new(dns.Msg)
+		m.SetRcode(req, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return n
+	}
+}
+
+func TestRetryBudgetStopsRetries(t *testing.T) {
+	domains := []string{"a.test", "b.test", "c.test"}
+	perLookup := 0
+	for _, tc := range []struct {
+		budget  int
+		retries string
+	}{
+		{0, "Retries: 0 (retry budget exhausted)"},
+		{2, "Retries: 2 (retry budget exhausted)"},
+	} {
+		addr, queries := servfailDNS(t)
+		args := []string{"--dns", addr, "--retries", "3", "--retry-budget", fmt.Sprint(tc.budget), "--stats"}
+		_, stderr := runCLI(t, append(args, domains...)...)
+		lookups := fmt.Sprintf("Lookups: %d (%d failed)", len(domains)+tc.budget, len(domains)+tc.budget)
+		if !strings.Contains(stderr, lookups) || !strings.Contains(stderr, tc.retries) {
+			t.Errorf("budget %d: want %q and %q in:\n%s", tc.budget, lookups, tc.retries, stderr)
+		}
+		// The resolver may send more than one query per lookup; every
+		// lookup sends the same number.
+		if tc.budget == 0 {
+			perLookup = queries() / len(domains)
+		}
+		if got, want := queries(), perLookup*(len(domains)+tc.budget); got != want {
+			t.Errorf("budget %d: server got %d queries, want %d", tc.budget, got, want)
+		}
+	}
+}
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	a := newAdaptiveConcurrency(2)
+	// run looks up n domains, failing those fail picks, and returns the
+	// most lookups in flight at once from the index from onwards.
+	run := func(n, from int, fail func(i int) bool) int {
+		var mu sync.Mutex
+		inFlight, peak := 0, 0
+		domains := make([]string, n)
+		a.forEach(domains, func(i int, _ string) bool {
+			mu.Lock()
+			inFlight++
+			if i >= from {
+				peak = max(peak, inFlight)
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return fail(i)
+		})
+		return peak
+	}
+	if peak := run(adaptWindow, 0, func(int) bool { return false }); peak < 2 {
+		t.Errorf("healthy lookups ran %d at a time, want several", peak)
+	}
+
+	// Every window failing halves the limit down to one lookup at a time.
+	if peak := run(10*adaptWindow, 8*adaptWindow, func(int) bool { return true }); peak != 1 || a.limit != a.min {
+		t.Errorf("after failures: limit %d, peak %d; want %d and 1", a.limit, peak, a.min)
+	}
+
+	// A failure rate of 10% is tolerated and leaves the limit alone.
+	a.limit = 8
+	run(adaptWindow, 0, func(i int) bool { return i%10 == 0 })
+	if a.limit != 8 {
+		t.Errorf("10%% failures changed the limit to %d, want 8", a.limit)
+	}
+
+	// Healthy windows ramp the limit back up to the maximum.
+	a.limit = a.min
+	run(30*adaptWindow, 0, func(int) bool { return false })
+	if a.limit != a.max {
+		t.Errorf("after healthy lookups: limit %d, want %d", a.limit, a.max)
+	}
+}
+
+func TestConcurrencyAutoBacksOffOnServfail(t *testing.T) {
+	addr, _ := servfailDNS(t)
+	domains := make([]string, 2*adaptWindow)
+	for i := range domains {
+		domains[i] = fmt.Sprintf("d%d.test", i)
+	}
+	args := append([]string{"--dns", addr, "--concurrency", "auto", "--verbose"}, domains...)
+	_, stderr := runCLI(t, args...)
+	var start, low, high int
+	_, logged, _ := strings.Cut(stderr, "Concurrency auto: starting")
+	if _, err := fmt.Sscanf(logged, " at %d (range %d-%d)", &start, &low, &high); err != nil {
+		t.Fatalf("no starting limit logged: %v\n%s", err, stderr)
+	}
+	want := fmt.Sprintf("Concurrency auto: %d/%d lookups failed; limit %d -> %d", adaptWindow, adaptWindow, start, start/2)
+	if !strings.Contains(stderr, want) {
+		t.Errorf("no %q in:\n%s", want, stderr)
+	}
+}
@@ -0,0 +1,208 @@
+//go:build tui
+
+// tui.go adds the --tui browser. It is only compiled with -tags tui, so the
+// default build stays free of the terminal UI libraries.
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+func init() {
+	runTUI = browseResults
+}
+
+// browseResults shows results as a table of domains that expand to their
+// records. Typing after / filters domains, keys, values and records; e
+// exports the filtered view to a file; q quits.
+func browseResults(results []DomainTXT, export func(path string, results []DomainTXT) error) error {
+	var order []string
+	byDomain := make(map[string][]DomainTXT)
+	for _, r := range results {
+		if _, ok := byDomain[r.Domain]; !ok {
+			order = append(order, r.Domain)
+		}
+		byDomain[r.Domain] = append(byDomain[r.Domain], r)
+	}
+
+	app := tview.NewApplication()
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	filterField := tview.NewInputField().SetLabel("Filter: ")
+	exportField := tview.NewInputField().SetLabel("Export to: ")
+	status := tview.NewTextView()
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(filterField, 1, 0, false).
+		AddItem(table, 0, 1, true).
+		AddItem(status, 1, 0, false)
+
+	filter := ""
+	expanded := make(map[string]bool)
+	// note is shown in the status line until the view next changes.
+	note := ""
+	// rowDomain is the domain each table row belongs to.
+	var rowDomain []string
+
+	// visible returns the results the filter keeps, grouped by domain in
+	// input order. A domain whose name matches keeps all its records.
+	visible := func() (domains []string, records map[string][]DomainTXT) {
+		records = make(map[string][]DomainTXT)
+		for _, domain := range order {
+			for _, r := range byDomain[domain] {
+				if filter == "" || strings.Contains(strings.ToLower(domain), filter) || matchesFilter(r, filter) {
+					records[domain] = append(records[domain], r)
+				}
+			}
+			if len(records[domain]) > 0 {
+				domains = append(domains, domain)
+			}
+		}
+		return domains, records
+	}
+
+	render := func() {
+		selected := ""
+		if row, _ := table.GetSelection(); row > 0 && row < len(rowDomain) {
+			selected = rowDomain[row]
+		}
+		table.Clear()
+		for col, title := range []string{"Domain", "Key", "Value", "Record"} {
+			table.SetCell(0, col, tview.NewTableCell(title).
+				SetAttributes(tcell.AttrBold).SetSelectable(false))
+		}
+		rowDomain = []string{""}
+		selectRow := 1
+		domains, records := visible()
+		count := 0
+		for _, domain := range domains {
+			recs := records[domain]
+			count += len(recs)
+			marker := "▸ "
+			if expanded[domain] {
+				marker = "▾ "
+			}
+			if domain == selected {
+				selectRow = len(rowDomain)
+			}
+			row := len(rowDomain)
+			table.SetCell(row, 0, tview.NewTableCell(marker+tview.Escape(domain)).SetTextColor(tcell.ColorYellow))
+			table.SetCell(row, 1, tview.NewTableCell(plural(len(recs), "record")).SetTextColor(tcell.ColorGray))
+			rowDomain = append(rowDomain, domain)
+			if !expanded[domain] {
+				continue
+			}
+			for _, r := range recs {
+				row := len(rowDomain)
+				table.SetCell(row, 0, tview.NewTableCell(""))
+				table.SetCell(row, 1, tview.NewTableCell(tview.Escape(r.Key)).SetTextColor(tcell.ColorTeal))
+				table.SetCell(row, 2, tview.NewTableCell(tview.Escape(r.Value)).SetMaxWidth(40))
+				table.SetCell(row, 3, tview.NewTableCell(tview.Escape(r.txtColumn())))
+				rowDomain = append(rowDomain, domain)
+			}
+		}
+		table.Select(selectRow, 0)
+		text := fmt.Sprintf("%d of %d domains, %s | Enter expand  / filter  e export  q quit", len(domains), len(order), plural(count, "record"))
+		if note != "" {
+			text = note + " | " + text
+		}
+		status.SetText(text)
+	}
+
+	// closeExport puts the status line back in place of the export prompt.
+	closeExport := func() {
+		layout.RemoveItem(exportField)
+		layout.AddItem(status, 1, 0, false)
+		app.SetFocus(table)
+	}
+
+	table.SetSelectedFunc(func(row, _ int) {
+		if row <= 0 || row >= len(rowDomain) {
+			return
+		}
+		domain := rowDomain[row]
+		expanded[domain] = !expanded[domain]
+		note = ""
+		render()
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape && filter != "":
+			filterField.SetText("")
+		case event.Rune() == '/':
+			app.SetFocus(filterField)
+		case event.Rune() == 'e':
+			layout.RemoveItem(status)
+			layout.AddItem(exportField, 1, 0, true)
+			app.SetFocus(exportField)
+		case event.Rune() == 'q':
+			app.Stop()
+		default:
+			return event
+		}
+		return nil
+	})
+
+	filterField.SetChangedFunc(func(text string) {
+		filter = strings.ToLower(strings.TrimSpace(text))
+		// Show the matching records of every domain left in view.
+		clear(expanded)
+		if filter != "" {
+			domains, _ := visible()
+			for _, domain := range domains {
+				expanded[domain] = true
+			}
+		}
+		note = ""
+		render()
+	})
+	filterField.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			filterField.SetText("")
+		}
+		app.SetFocus(table)
+	})
+
+	exportField.SetDoneFunc(func(key tcell.Key) {
+		path := strings.TrimSpace(exportField.GetText())
+		if key == tcell.KeyEnter && path != "" {
+			domains, records := visible()
+			var view []DomainTXT
+			for _, domain := range domains {
+				view = append(view, records[domain]...)
+			}
+			if err := export(path, view); err != nil {
+				note = fmt.Sprintf("Error exporting to %s: %v", path, err)
+			} else {
+				note = fmt.Sprintf("Exported %s to %s", plural(len(view), "record"), path)
+			}
+			exportField.SetText("")
+		}
+		closeExport()
+		render()
+	})
+
+	render()
+	return app.SetRoot(layout, true).EnableMouse(true).Run()
+}
+
+// matchesFilter reports whether the key, value or text of r contains
+// filter, which must be lowercase.
+func matchesFilter(r DomainTXT, filter string) bool {
+	for _, field := range []string{r.Key, r.Value, r.TXT} {
+		if strings.Contains(strings.ToLower(field), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// plural formats n with noun, adding an s unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}